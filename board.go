@@ -31,9 +31,12 @@ type BitBoard struct {
 	// Bit 10-16: The count since the last piece capture or pawn move. if this counter passes 100, the game is draw.
 	// Bit 17-24: The move counter. Incremented after black has moved.
 	Flags uint32
+
+	// Zobrist key of the position. Kept up to date incrementally by transition.
+	Key uint64
 }
 
-var StartBoard = BitBoard{
+var StartBoard = withZobristKey(BitBoard{
 	WhiteBB:  uint64(0x000000000000ffff),
 	BlackBB:  uint64(0xffff000000000000),
 	PawnBB:   uint64(0x00ff00000000ff00),
@@ -42,25 +45,18 @@ var StartBoard = BitBoard{
 	RookBB:   uint64(0x8100000000000081),
 	QueenBB:  uint64(0x0800000000000008),
 	KingBB:   uint64(0x1000000000000010),
-	Flags:    uint32(0x000001FE),
-}
+	Flags:    uint32(0x000203C0),
+})
 
+// Hash returns the Zobrist key of the position. Turn, castling rights and en passant are included,
+// but the move counters are not.
 func (b *BitBoard) Hash() uint64 {
-	combine := func(a uint64, b uint64) uint64 {
-		return a + 0x9e3779b9 + (b << 6) + (a >> 2)
-	}
+	return b.Key
+}
 
-	h := MurmurHash(b.WhiteBB)
-	h = combine(h, MurmurHash(b.BlackBB))
-	h = combine(h, MurmurHash(b.PawnBB))
-	h = combine(h, MurmurHash(b.KnightBB))
-	h = combine(h, MurmurHash(b.BishopBB))
-	h = combine(h, MurmurHash(b.RookBB))
-	h = combine(h, MurmurHash(b.QueenBB))
-	h = combine(h, MurmurHash(b.KingBB))
-	// Include Flags (bits 0-9: turn, en passant, castling) but exclude move counters (bits 10-24)
-	h = combine(h, MurmurHash(uint64(b.Flags & 0x3FF )))
-	return h
+// HalfMoveClock returns the number of plies since the last capture or pawn move.
+func (b *BitBoard) HalfMoveClock() int {
+	return int(b.Flags >> 10 & uint32(127))
 }
 
 func (b *BitBoard) TurnCount() int {
@@ -124,7 +120,7 @@ func (b *BitBoard) BlackCanCastleQueenSite() bool {
 }
 
 func (b *BitBoard) IsEmpty(pos int) bool {
-	if pos > 0 {
+	if pos >= 0 {
 		return posToBitBoard(pos)&(b.WhiteBB|b.BlackBB) == 0
 	} else {
 		return true
@@ -279,12 +275,10 @@ func (b BitBoard) ToFEN() string {
 	}
 
 	var enPassentString string
-	enPassantFile := int(b.Flags >> 1 & uint32(15))
-	if enPassantFile > 7 {
+	if enPassantFile := b.DoublePawnMoveFile(); enPassantFile < 1 {
 		enPassentString = "-"
 	} else {
-		// The file number is 0-indexed, while the mapping is 1-indexed.
-		file := FileToLetter[enPassantFile+1]
+		file := FileToLetter[enPassantFile]
 		if b.Turn() == White {
 			// Black moved previous turn, and the pawn rank is 6
 			enPassentString = file + "6"
@@ -293,7 +287,7 @@ func (b BitBoard) ToFEN() string {
 		}
 	}
 
-	halfMoveClock := int(b.Flags >> 10 & uint32(127))
+	halfMoveClock := b.HalfMoveClock()
 	moveCounter := int(b.Flags >> 17)
 
 	values := []string{
@@ -462,7 +456,7 @@ func BoardFromFEN(fen string) BitBoard {
 		Flags:    flags,
 	}
 
-	return board
+	return withZobristKey(board)
 }
 
 func Pretty64(number uint64) {
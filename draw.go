@@ -0,0 +1,55 @@
+package main
+
+import "math/bits"
+
+// Dark squares of the board, used to tell the colors of bishops apart.
+const darkSquares = uint64(0xaa55aa55aa55aa55)
+
+// The halfmove clock value at which the fifty-move rule makes the game a draw.
+const fiftyMoveLimit = 100
+
+// isInsufficientMaterial reports whether neither side can ever checkmate, whatever the moves. That is the case
+// with only kings and at most one minor piece, or with only kings and bishops that are all on the same color.
+func isInsufficientMaterial(b *BitBoard) bool {
+	if b.PawnBB|b.RookBB|b.QueenBB != 0 {
+		return false
+	}
+	minors := b.KnightBB | b.BishopBB
+	if bits.OnesCount64(minors) <= 1 {
+		return true
+	}
+	return b.KnightBB == 0 && (b.BishopBB&darkSquares == 0 || b.BishopBB&^darkSquares == 0)
+}
+
+// isRepetition reports whether the position on b occurred before. The positions before it are the boards on
+// the undo stack, preceded by history, the keys of the game positions before the search started. Only
+// positions since the last capture or pawn move can repeat, and only those with the same side to move.
+func isRepetition(b *BitBoard, undo *UndoStack, history []uint64) bool {
+	pliesBack := min(b.HalfMoveClock(), undo.Len()+len(history))
+	for i := 2; i <= pliesBack; i += 2 {
+		var key uint64
+		if i <= undo.Len() {
+			key = undo.boards[undo.Len()-i].Key
+		} else {
+			key = history[len(history)-(i-undo.Len())]
+		}
+		if key == b.Key {
+			return true
+		}
+	}
+	return false
+}
+
+// isFiftyMoveDraw reports whether fifty moves by each side have been played without a capture or pawn move.
+// A checkmate delivered by the last of these moves still counts as a win.
+func isFiftyMoveDraw(b *BitBoard) bool {
+	if b.HalfMoveClock() < fiftyMoveLimit {
+		return false
+	}
+	if !b.InCheck() {
+		return true
+	}
+	var moves MoveList
+	GenerateMoves(b, GenAll, &moves)
+	return moves.Count > 0
+}
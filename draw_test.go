@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsInsufficientMaterial(t *testing.T) {
+	tests := []struct {
+		name string
+		fen  string
+		want bool
+	}{
+		{"KvK", "4k3/8/8/8/8/8/8/4K3 w - - 0 1", true},
+		{"KBvK", "4k3/8/8/8/8/8/8/2B1K3 w - - 0 1", true},
+		{"KvKN", "4k1n1/8/8/8/8/8/8/4K3 w - - 0 1", true},
+		{"KBvKB same color bishops", "4kb2/8/8/8/8/8/8/2B1K3 w - - 0 1", true},
+		{"KBBvKB same color bishops", "4kb2/8/8/8/8/8/8/B1B1K3 w - - 0 1", true},
+		{"KBvKB opposite color bishops", "2b1k3/8/8/8/8/8/8/2B1K3 w - - 0 1", false},
+		{"KNvKB", "2b1k3/8/8/8/8/8/8/1N2K3 w - - 0 1", false},
+		{"KNNvK", "4k3/8/8/8/8/8/8/1N2KN2 w - - 0 1", false},
+		{"KPvK", "4k3/8/8/8/8/8/4P3/4K3 w - - 0 1", false},
+		{"KRvK", "4k3/8/8/8/8/8/8/R3K3 w - - 0 1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			board := BoardFromFEN(tt.fen)
+			if got := isInsufficientMaterial(&board); got != tt.want {
+				t.Errorf("isInsufficientMaterial(%s) = %v, want %v", tt.fen, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsRepetition(t *testing.T) {
+	const start = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+	tests := []struct {
+		name string
+		fen  string
+		// Moves played before the search, which are passed as history keys.
+		game string
+		// Moves played in the search with MakeMove.
+		search string
+		want   bool
+	}{
+		{"no moves", start, "", "", false},
+		{"knights out", start, "", "g1f3 g8f6", false},
+		{"knights back in the search", start, "", "g1f3 g8f6 f3g1 f6g8", true},
+		{"knights back in the game", start, "g1f3 g8f6 f3g1 f6g8", "", true},
+		{"repetition spans game and search", start, "g1f3 g8f6", "f3g1 f6g8", true},
+		{"threefold", start, "g1f3 g8f6 f3g1 f6g8 g1f3 g8f6", "f3g1 f6g8", true},
+		{"pawn move in between", start, "g1f3 g8f6 f3g1 f6g8", "e2e4 e7e5 g1f3 g8f6 f3g1 f6g8", true},
+		{"only positions after the pawn move count", start, "g1f3 g8f6 f3g1 f6g8 e2e4", "", false},
+		{"rook and king shuffle", "4k3/8/8/8/8/8/8/R3K3 w - - 0 1", "a1a2 e8d8 a2a1", "d8e8", true},
+		{"castling rights lost in between", "4k3/8/8/8/8/8/8/R3K3 w Q - 0 1", "e1f1 e8d8", "f1e1 d8e8", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			board := BoardFromFEN(tt.fen)
+			var history []uint64
+			for _, uciMove := range strings.Fields(tt.game) {
+				history = append(history, board.Hash())
+				board = playMove(t, board, uciMove, NewUndoStack())
+			}
+			undo := NewUndoStack()
+			for _, uciMove := range strings.Fields(tt.search) {
+				board = playMove(t, board, uciMove, undo)
+			}
+			if got := isRepetition(&board, undo, history); got != tt.want {
+				t.Errorf("isRepetition after %q and %q = %v, want %v", tt.game, tt.search, got, tt.want)
+			}
+		})
+	}
+}
+
+func playMove(t *testing.T, board BitBoard, uciMove string, undo *UndoStack) BitBoard {
+	t.Helper()
+	move, ok := UCIToMove(board, uciMove)
+	if !ok {
+		t.Fatalf("%s is not legal in %s", uciMove, board.ToFEN())
+	}
+	board.MakeMove(move, undo)
+	return board
+}
@@ -1,14 +1,34 @@
 package main
 
-import (
-	"math/bits"
-)
+import "math/bits"
 
+// Eval scores the board in pawns from white's point of view. Every term has an early and a late game value,
+// which are blended by the phase of the game, so the score changes gradually as pieces are traded.
 func Eval(board *BitBoard) float64 {
-	p := psq(board) / 100
-	m := material(board)
-	score := p + m
-	return score
+	return float64(evalCentipawns(board)) / 100
+}
+
+func evalCentipawns(board *BitBoard) int {
+	early, late := pawns(board)
+	whiteEarly, whiteLate := sideScore(board, White)
+	blackEarly, blackLate := sideScore(board, Black)
+	return taper(early+whiteEarly-blackEarly, late+whiteLate-blackLate, gamePhase(board))
+}
+
+// sideScore adds up the terms of one color for the early and the late game, except for the pawns, which are
+// evaluated for both colors at once so they can be cached.
+func sideScore(board *BitBoard, color Color) (early int, late int) {
+	add := func(termEarly int, termLate int) {
+		early += termEarly
+		late += termLate
+	}
+	add(material(board, color))
+	add(psq(board, color))
+	kingEarly, kingLate, _ := kingSafety(board, color)
+	add(kingEarly, kingLate)
+	add(mobility(board, color))
+	add(pieces(board, color))
+	return early, late
 }
 
 const (
@@ -20,6 +40,43 @@ const (
 	kingWeight   = 100.0
 )
 
+// Material in centipawns for the early and the late game, indexed by Piece. The minor pieces lose some of
+// their value as the board empties, while rooks and pawns gain some.
+var (
+	materialEarly = [5]int{
+		int(pawnWeight * 100),
+		int(knightWeigh * 100),
+		int(bishopWeight * 100),
+		int(rookWeight * 100),
+		int(queenWeight * 100),
+	}
+	materialLate = [5]int{120, 260, 295, 530, 940}
+)
+
+// The phase is the non-pawn material left on the board, counted with these weights. It goes from maxPhase
+// with all pieces on the board down to 0 with only kings and pawns left.
+const (
+	knightPhase = 1
+	bishopPhase = 1
+	rookPhase   = 2
+	queenPhase  = 4
+	maxPhase    = 4*knightPhase + 4*bishopPhase + 4*rookPhase + 2*queenPhase
+)
+
+func gamePhase(board *BitBoard) int {
+	phase := bits.OnesCount64(board.KnightBB)*knightPhase +
+		bits.OnesCount64(board.BishopBB)*bishopPhase +
+		bits.OnesCount64(board.RookBB)*rookPhase +
+		bits.OnesCount64(board.QueenBB)*queenPhase
+	// Promotions can take the count above the starting material.
+	return min(phase, maxPhase)
+}
+
+// taper blends an early and a late game score by the phase of the game.
+func taper(early int, late int, phase int) int {
+	return (early*phase + late*(maxPhase-phase)) / maxPhase
+}
+
 var (
 	PsqPawnWhiteEarly   = extractPsqScores(psqPawn, true, true)
 	PsqPawnWhiteLate    = extractPsqScores(psqPawn, false, true)
@@ -47,61 +104,46 @@ var (
 	PsqKingBlackEarly   = extractPsqScores(psqKing, true, false)
 )
 
-func material(board *BitBoard) float64 {
-	return float64(bits.OnesCount64(board.WhiteBB&board.PawnBB))*pawnWeight +
-		float64(bits.OnesCount64(board.WhiteBB&board.KnightBB))*knightWeigh +
-		float64(bits.OnesCount64(board.WhiteBB&board.BishopBB))*bishopWeight +
-		float64(bits.OnesCount64(board.WhiteBB&board.RookBB))*rookWeight +
-		float64(bits.OnesCount64(board.WhiteBB&board.QueenBB))*queenWeight -
-		float64(bits.OnesCount64(board.BlackBB&board.PawnBB))*pawnWeight -
-		float64(bits.OnesCount64(board.BlackBB&board.KnightBB))*knightWeigh -
-		float64(bits.OnesCount64(board.BlackBB&board.BishopBB))*bishopWeight -
-		float64(bits.OnesCount64(board.BlackBB&board.RookBB))*rookWeight -
-		float64(bits.OnesCount64(board.BlackBB&board.QueenBB))*queenWeight
+// Piece-square tables indexed by color and Piece.
+var (
+	psqEarly = [2][6]*[64]int{
+		{&PsqPawnWhiteEarly, &PsqKnightWhiteEarly, &PsqBishopWhiteEarly, &PsqRookWhiteEarly, &PsqQueenWhiteEarly, &PsqKingWhiteEarly},
+		{&PsqPawnBlackEarly, &PsqKnightBlackEarly, &PsqBishopBlackEarly, &PsqRookBlackEarly, &PsqQueenBlackEarly, &PsqKingBlackEarly},
+	}
+	psqLate = [2][6]*[64]int{
+		{&PsqPawnWhiteLate, &PsqKnightWhiteLate, &PsqBishopWhiteLate, &PsqRookWhiteLate, &PsqQueenWhiteLate, &PsqKingWhiteLate},
+		{&PsqPawnBlackLate, &PsqKnightBlackLate, &PsqBishopBlackLate, &PsqRookBlackLate, &PsqQueenBlackLate, &PsqKingBlackLate},
+	}
+)
+
+// material returns the material of color in centipawns for the early and the late game.
+func material(board *BitBoard, color Color) (early int, late int) {
+	us := colorBoard(board, color)
+	for piece, pieceBB := range [5]uint64{board.PawnBB, board.KnightBB, board.BishopBB, board.RookBB, board.QueenBB} {
+		count := bits.OnesCount64(us & pieceBB)
+		early += count * materialEarly[piece]
+		late += count * materialLate[piece]
+	}
+	return early, late
 }
 
-func psq(board *BitBoard) float64 {
-	score := func(pieceBoard uint64, psq *[64]float64) float64 {
-		var sum float64
-		for pieceBoard > 0 {
-			bit, pb := PopFistBit(pieceBoard)
-			pieceBoard = pb
-			sum += psq[bit]
+// psq returns the piece-square score of color in centipawns for the early and the late game.
+func psq(board *BitBoard, color Color) (early int, late int) {
+	us := colorBoard(board, color)
+	for piece, pieceBB := range [6]uint64{board.PawnBB, board.KnightBB, board.BishopBB, board.RookBB, board.QueenBB, board.KingBB} {
+		for remaining := pieceBB & us; remaining != 0; {
+			square, rest := PopFistBit(remaining)
+			remaining = rest
+			early += psqEarly[color][piece][square]
+			late += psqLate[color][piece][square]
 		}
-		return sum
-	}
-	if board.TurnCount() <= 40 {
-		return score(board.WhiteBB&board.PawnBB, &PsqPawnWhiteEarly) +
-			score(board.WhiteBB&board.KnightBB, &PsqKnightWhiteEarly) +
-			score(board.WhiteBB&board.BishopBB, &PsqBishopWhiteEarly) +
-			score(board.WhiteBB&board.RookBB, &PsqRookWhiteEarly) +
-			score(board.WhiteBB&board.QueenBB, &PsqQueenWhiteEarly) +
-			score(board.WhiteBB&board.KingBB, &PsqKingWhiteEarly) -
-			score(board.BlackBB&board.PawnBB, &PsqPawnBlackEarly) -
-			score(board.BlackBB&board.KnightBB, &PsqKnightBlackEarly) -
-			score(board.BlackBB&board.BishopBB, &PsqBishopBlackEarly) -
-			score(board.BlackBB&board.RookBB, &PsqRookBlackEarly) -
-			score(board.BlackBB&board.QueenBB, &PsqQueenBlackEarly) -
-			score(board.BlackBB&board.KingBB, &PsqKingBlackEarly)
-	} else {
-		return score(board.WhiteBB&board.PawnBB, &PsqPawnWhiteLate) +
-			score(board.WhiteBB&board.KnightBB, &PsqKnightWhiteLate) +
-			score(board.WhiteBB&board.BishopBB, &PsqBishopWhiteLate) +
-			score(board.WhiteBB&board.RookBB, &PsqRookWhiteLate) +
-			score(board.WhiteBB&board.QueenBB, &PsqQueenWhiteLate) +
-			score(board.WhiteBB&board.KingBB, &PsqKingWhiteLate) -
-			score(board.BlackBB&board.PawnBB, &PsqPawnBlackLate) -
-			score(board.BlackBB&board.KnightBB, &PsqKnightBlackLate) -
-			score(board.BlackBB&board.BishopBB, &PsqBishopBlackLate) -
-			score(board.BlackBB&board.RookBB, &PsqRookBlackLate) -
-			score(board.BlackBB&board.QueenBB, &PsqQueenBlackLate) -
-			score(board.BlackBB&board.KingBB, &PsqKingBlackLate)
 	}
+	return early, late
 }
 
-func extractPsqScores(psq [][]int, earlyGame bool, white bool) [64]float64 {
+func extractPsqScores(psq [][]int, earlyGame bool, white bool) [64]int {
 	// create a map based on psq and use the first element if earlyGame is true
-	var psqMapping [64]float64
+	var psqMapping [64]int
 	for i, values := range psq {
 		var square int
 		if white {
@@ -113,11 +155,20 @@ func extractPsqScores(psq [][]int, earlyGame bool, white bool) [64]float64 {
 			square = sq
 		}
 		if earlyGame {
-			psqMapping[square] = float64(values[0]) / 100
+			psqMapping[square] = values[0]
 		} else {
-			psqMapping[square] = float64(values[1]) / 100
+			psqMapping[square] = values[1]
 		}
 	}
 
 	return psqMapping
 }
+
+// evaluate is Eval in centipawns from the perspective of the side to move, which is what the search works with.
+func evaluate(board *BitBoard) int {
+	score := evalCentipawns(board)
+	if board.Turn() == Black {
+		return -score
+	}
+	return score
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EvalTerm is one evaluation component in centipawns, for the early and the late game and blended by the
+// phase of the game.
+type EvalTerm struct {
+	Early   int `json:"early"`
+	Late    int `json:"late"`
+	Blended int `json:"blended"`
+}
+
+// EvalSide holds the evaluation components of one color, each from that color's point of view.
+type EvalSide struct {
+	Material   EvalTerm `json:"material"`
+	PSQ        EvalTerm `json:"psq"`
+	Pawns      EvalTerm `json:"pawns"`
+	KingSafety EvalTerm `json:"kingSafety"`
+	// The part of the king safety that comes from enemy pieces attacking the king zone.
+	KingDanger int      `json:"kingDanger"`
+	Mobility   EvalTerm `json:"mobility"`
+	Pieces     EvalTerm `json:"pieces"`
+	Total      EvalTerm `json:"total"`
+}
+
+// EvalBreakdown is the evaluation of a board split into its components.
+type EvalBreakdown struct {
+	White EvalSide `json:"white"`
+	Black EvalSide `json:"black"`
+	// From maxPhase with all pieces on the board down to 0 with only kings and pawns left.
+	Phase int `json:"phase"`
+	// The blended score in centipawns from white's point of view. It is the same as Eval.
+	Score int `json:"score"`
+}
+
+// EvalTrace evaluates the board like Eval, but returns every component for both colors, to show which
+// terms drive the score.
+func EvalTrace(board *BitBoard) EvalBreakdown {
+	phase := gamePhase(board)
+	term := func(early int, late int) EvalTerm {
+		return EvalTerm{Early: early, Late: late, Blended: taper(early, late, phase)}
+	}
+
+	whitePawns, blackPawns := board.PawnBB&board.WhiteBB, board.PawnBB&board.BlackBB
+	side := func(color Color) EvalSide {
+		ours, theirs := whitePawns, blackPawns
+		if color == Black {
+			ours, theirs = theirs, ours
+		}
+		pawnsEarly, pawnsLate, passed := pawnStructure(color, ours, theirs)
+		passedEarly, passedLate := passedPawns(board, color, passed)
+		kingEarly, kingLate, danger := kingSafety(board, color)
+		totalEarly, totalLate := sideScore(board, color)
+
+		return EvalSide{
+			Material:   term(material(board, color)),
+			PSQ:        term(psq(board, color)),
+			Pawns:      term(pawnsEarly+passedEarly, pawnsLate+passedLate),
+			KingSafety: term(kingEarly, kingLate),
+			KingDanger: danger,
+			Mobility:   term(mobility(board, color)),
+			Pieces:     term(pieces(board, color)),
+			Total:      term(totalEarly+pawnsEarly+passedEarly, totalLate+pawnsLate+passedLate),
+		}
+	}
+
+	return EvalBreakdown{
+		White: side(White),
+		Black: side(Black),
+		Phase: phase,
+		Score: evalCentipawns(board),
+	}
+}
+
+// String formats the breakdown as a table with the early, late and blended value of every component.
+func (e EvalBreakdown) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%-12s|%24s |%24s\n", "Term", "White", "Black")
+	fmt.Fprintf(&sb, "%-12s|%8s%8s%8s |%8s%8s%8s\n", "", "early", "late", "blended", "early", "late", "blended")
+	row := func(name string, white EvalTerm, black EvalTerm) {
+		fmt.Fprintf(&sb, "%-12s|%8d%8d%8d |%8d%8d%8d\n", name,
+			white.Early, white.Late, white.Blended, black.Early, black.Late, black.Blended)
+	}
+	row("Material", e.White.Material, e.Black.Material)
+	row("PSQ", e.White.PSQ, e.Black.PSQ)
+	row("Pawns", e.White.Pawns, e.Black.Pawns)
+	row("King safety", e.White.KingSafety, e.Black.KingSafety)
+	row("Mobility", e.White.Mobility, e.Black.Mobility)
+	row("Pieces", e.White.Pieces, e.Black.Pieces)
+	row("Total", e.White.Total, e.Black.Total)
+	fmt.Fprintf(&sb, "King danger: white %d, black %d\n", e.White.KingDanger, e.Black.KingDanger)
+	fmt.Fprintf(&sb, "Phase: %d/%d\n", e.Phase, maxPhase)
+	fmt.Fprintf(&sb, "Score: %+.2f (white's point of view)\n", float64(e.Score)/100)
+	return sb.String()
+}
@@ -1,4 +1,4 @@
-module main
+module github.com/sberglann/go-chess
 
 go 1.25.5
 
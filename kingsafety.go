@@ -0,0 +1,119 @@
+package main
+
+import "math/bits"
+
+// King safety scores in centipawns. The pawn cover only matters while there is enough material on the board
+// to attack the king, so it is scored for the early game only. Shield and storm tables are indexed by how
+// many ranks in front of the king the closest pawn on a file is, where 0 means there is none.
+const (
+	openFileNearKing     = -25
+	halfOpenFileNearKing = -12
+)
+
+var (
+	pawnShield = [8]int{-10, 20, 10, 3, 0, 0, 0, 0}
+	pawnStorm  = [8]int{0, -5, -25, -15, -5, 0, 0, 0}
+)
+
+// Weight of a piece attacking the king zone, indexed by Piece, and the weight of every attacked square in it.
+var kingAttackWeight = [6]int{0, 20, 20, 40, 80, 0}
+
+const kingZoneSquareWeight = 8
+
+// The share of the attack weight in percent that counts as danger, indexed by the number of attackers. A
+// single piece rarely mates, but every piece joining the attack makes it much more dangerous.
+var kingAttackScaling = [8]int{0, 0, 50, 75, 88, 94, 97, 99}
+
+// The king zone is the squares around the king, and the ones in front of those towards the enemy.
+var kingZones = KingZones()
+
+func KingZones() [2][64]uint64 {
+	var zones [2][64]uint64
+	for square := 0; square < 64; square++ {
+		around := kingMasks[square] | posToBitBoard(square)
+		zones[White][square] = around | around<<8
+		zones[Black][square] = around | around>>8
+	}
+	return zones
+}
+
+// kingSafety scores the safety of color's king for the early and the late game. A negative score means the
+// king is exposed. danger is the part that comes from enemy pieces attacking the king zone.
+func kingSafety(board *BitBoard, color Color) (early int, late int, danger int) {
+	king := LSB(board.KingBB & colorBoard(board, color))
+	if king == 64 {
+		return 0, 0, 0
+	}
+	danger = kingDanger(board, color, king)
+	return pawnCover(board, color, king) - danger, -danger / 4, danger
+}
+
+// pawnCover scores the files around the king: the own pawns shielding it, the enemy pawns advancing on it,
+// and files without pawns that rooks and queens can use to attack it.
+func pawnCover(board *BitBoard, color Color, king int) int {
+	ours := board.PawnBB & colorBoard(board, color)
+	theirs := board.PawnBB & colorBoard(board, color.Opposite())
+	kingRank := relativeRank(color, king)
+	// Look at three files, also when the king is on the edge of the board.
+	center := min(max(king%8, 1), 6)
+
+	score := 0
+	for file := center - 1; file <= center+1; file++ {
+		inFront := frontSpans[color][king/8*8+file]
+		ourPawns, theirPawns := ours&inFront, theirs&inFront
+		score += pawnShield[closestPawnDistance(color, ourPawns, kingRank)]
+		score += pawnStorm[closestPawnDistance(color, theirPawns, kingRank)]
+
+		switch {
+		case ours&fileMasks[file] == 0 && theirs&fileMasks[file] == 0:
+			score += openFileNearKing
+		case ours&fileMasks[file] == 0:
+			score += halfOpenFileNearKing
+		}
+	}
+	return score
+}
+
+// closestPawnDistance is the number of ranks between the king and the pawn closest to it, or 0 if there is
+// no pawn.
+func closestPawnDistance(color Color, pawns uint64, kingRank int) int {
+	if pawns == 0 {
+		return 0
+	}
+	var closest int
+	if color == White {
+		closest = LSB(pawns)
+	} else {
+		closest = 63 - bits.LeadingZeros64(pawns)
+	}
+	return min(relativeRank(color, closest)-kingRank, 7)
+}
+
+// kingDanger adds up the enemy knights, bishops, rooks and queens that attack the zone around color's king,
+// using the same magic attack lookups as the move generator. The total weight of the attackers and the
+// attacked squares is scaled by the number of attackers, and halved when the enemy has no queen.
+func kingDanger(board *BitBoard, color Color, king int) int {
+	zone := kingZones[color][king]
+	them := colorBoard(board, color.Opposite())
+	occupied := board.WhiteBB | board.BlackBB
+
+	attackers, weight := 0, 0
+	for piece, pieceBB := range [6]uint64{0, board.KnightBB, board.BishopBB, board.RookBB, board.QueenBB, 0} {
+		for remaining := pieceBB & them; remaining != 0; {
+			square, rest := PopFistBit(remaining)
+			remaining = rest
+			attacked := pieceAttacks(Piece(piece), square, occupied) & zone
+			if attacked == 0 {
+				continue
+			}
+			attackers++
+			weight += kingAttackWeight[piece] + kingZoneSquareWeight*bits.OnesCount64(attacked)
+		}
+	}
+
+	danger := weight * kingAttackScaling[min(attackers, 7)] / 100
+	if board.QueenBB&them == 0 {
+		danger /= 2
+	}
+	return danger
+}
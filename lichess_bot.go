@@ -2,21 +2,40 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 const LichessAPIBase = "https://lichess.org"
 
+// Correspondence games report clocks of several days. Don't spend more than this on a single move.
+const maxBotMoveTime = 30 * time.Second
+
+// Centipawns the bot gives up to avoid a draw by repetition or the fifty-move rule.
+const botContempt = 10
+
 type LichessBot struct {
 	token      string
 	httpClient *http.Client
 	userID     string // Cache user ID to avoid repeated API calls
+
+	// Skill level per opponent user ID, from LICHESS_SKILL_LEVELS, for example "alice=5,bob=12".
+	skillLevels map[string]int
+	// Opponents without a skill level of their own are played at their rating plus this offset, from
+	// LICHESS_RATING_OFFSET. Without it, the bot plays them at full strength.
+	ratingOffset *int
+	// Number of games being played. The CPUs are split between them, so their searches don't oversubscribe it.
+	activeGames atomic.Int32
 }
 
 type Challenge struct {
@@ -74,12 +93,14 @@ type GameFull struct {
 		Name string `json:"name"`
 	} `json:"perf"`
 	White struct {
-		ID   string `json:"id"`
-		Name string `json:"name"`
+		ID     string `json:"id"`
+		Name   string `json:"name"`
+		Rating int    `json:"rating"`
 	} `json:"white"`
 	Black struct {
-		ID   string `json:"id"`
-		Name string `json:"name"`
+		ID     string `json:"id"`
+		Name   string `json:"name"`
+		Rating int    `json:"rating"`
 	} `json:"black"`
 	InitialFen string `json:"initialFen"`
 		State      struct {
@@ -104,6 +125,46 @@ func NewLichessBot(token string) *LichessBot {
 	}
 }
 
+// configureStrength parses the skill levels per opponent, formatted as comma separated "user=level" pairs,
+// and the rating offset. Empty strings leave the setting unset.
+func (bot *LichessBot) configureStrength(skillLevels string, ratingOffset string) error {
+	bot.skillLevels = make(map[string]int)
+	for _, pair := range strings.Split(skillLevels, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		user, value, found := strings.Cut(pair, "=")
+		level, err := strconv.Atoi(strings.TrimSpace(value))
+		if !found || err != nil || level < 0 || level > maxSkillLevel {
+			return fmt.Errorf("invalid skill level: %s", pair)
+		}
+		bot.skillLevels[strings.ToLower(strings.TrimSpace(user))] = level
+	}
+
+	if ratingOffset != "" {
+		offset, err := strconv.Atoi(ratingOffset)
+		if err != nil {
+			return fmt.Errorf("invalid rating offset: %s", ratingOffset)
+		}
+		bot.ratingOffset = &offset
+	}
+	return nil
+}
+
+// opponentSkill returns the playing strength against an opponent, or nil for full strength.
+func (bot *LichessBot) opponentSkill(opponentID string, rating int) *Skill {
+	skill := Skill{Level: maxSkillLevel}
+	if level, found := bot.skillLevels[strings.ToLower(opponentID)]; found {
+		skill = Skill{Level: level}
+	} else if bot.ratingOffset != nil && rating > 0 {
+		skill = SkillFromElo(rating + *bot.ratingOffset)
+	}
+	if !skill.limitsStrength() {
+		return nil
+	}
+	return &skill
+}
+
 func (bot *LichessBot) makeRequest(method, url string, body io.Reader) (*http.Response, error) {
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
@@ -375,6 +436,9 @@ func (bot *LichessBot) handleChallenge(challenge Challenge) {
 }
 
 func (bot *LichessBot) handleGame(gameID string) {
+	bot.activeGames.Add(1)
+	defer bot.activeGames.Add(-1)
+
 	url := fmt.Sprintf("%s/api/bot/game/stream/%s", LichessAPIBase, gameID)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -402,6 +466,17 @@ func (bot *LichessBot) handleGame(gameID string) {
 	}
 	
 	var currentBoard BitBoard
+	// Keys of the positions before currentBoard, oldest first, to detect repetitions.
+	var history []uint64
+	// Playing strength against this opponent, nil for full strength.
+	var skill *Skill
+	// Search on the opponent's time, between our move and the next gameState event.
+	var ponder *botPonder
+	defer func() {
+		if ponder != nil {
+			ponder.stop()
+		}
+	}()
 	var isWhite bool
 	var initialFenForGame string // Store initial FEN for this game
 	
@@ -443,6 +518,15 @@ func (bot *LichessBot) handleGame(gameID string) {
 			
 			isWhite = gameFull.White.ID == bot.userID
 			log.Printf("Game %s started. Playing as %s", gameID, map[bool]string{true: "White", false: "Black"}[isWhite])
+
+			opponentID, opponentRating := gameFull.White.ID, gameFull.White.Rating
+			if isWhite {
+				opponentID, opponentRating = gameFull.Black.ID, gameFull.Black.Rating
+			}
+			skill = bot.opponentSkill(opponentID, opponentRating)
+			if skill != nil {
+				log.Printf("Game %s: playing %s (%d) at skill level %d", gameID, opponentID, opponentRating, skill.Level)
+			}
 			
 			// Store initial FEN for this game (convert "startpos" to actual FEN)
 			initialFenForGame = gameFull.InitialFen
@@ -469,6 +553,7 @@ func (bot *LichessBot) handleGame(gameID string) {
 			
 			// Set up board from FEN
 			currentBoard = BoardFromFEN(currentFen)
+			history = nil
 			
 			// If there are moves, apply them to get to current position
 			if hasMoves {
@@ -479,6 +564,7 @@ func (bot *LichessBot) handleGame(gameID string) {
 						log.Printf("Warning: Could not apply move %s. Current FEN: %s", uciMove, currentBoard.ToFEN())
 						break
 					}
+					history = append(history, currentBoard.Hash())
 					currentBoard = newBoard
 				}
 				log.Printf("Reconstructed board from moves. Current FEN: %s", currentBoard.ToFEN())
@@ -487,7 +573,8 @@ func (bot *LichessBot) handleGame(gameID string) {
 			// Check if it's our turn
 			if (isWhite && currentBoard.Turn() == White) || (!isWhite && currentBoard.Turn() == Black) {
 				log.Printf("It's our turn! Making move from gameFull...")
-				bot.makeBotMove(gameID, currentBoard)
+				tc := botTimeControl(&currentBoard, gameFull.State.WTime, gameFull.State.BTime, gameFull.State.WInc, gameFull.State.BInc)
+				ponder = bot.makeBotMove(gameID, currentBoard, history, skill, tc, ponder)
 			} else {
 				log.Printf("Not our turn yet. Waiting for gameState event...")
 			}
@@ -530,6 +617,8 @@ func (bot *LichessBot) handleGame(gameID string) {
 			if gameState.Fen != "" {
 				log.Printf("Received gameState. FEN: %s", gameState.Fen)
 				currentBoard = BoardFromFEN(gameState.Fen)
+				// The positions before it are unknown, so repetitions can't be detected.
+				history = nil
 				log.Printf("Parsed board. Board FEN: %s", currentBoard.ToFEN())
 			} else if gameState.Moves != "" {
 				// Reconstruct board from moves
@@ -540,8 +629,9 @@ func (bot *LichessBot) handleGame(gameID string) {
 					initialFenForGame = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
 				}
 				currentBoard = BoardFromFEN(initialFenForGame)
+				history = nil
 				
-				// Apply all moves
+				// Apply all moves, and remember the positions they pass through
 				movesList := strings.Fields(gameState.Moves)
 				for _, uciMove := range movesList {
 					newBoard, ok := ApplyUCIMove(currentBoard, uciMove)
@@ -549,6 +639,7 @@ func (bot *LichessBot) handleGame(gameID string) {
 						log.Printf("Warning: Could not apply move %s. Current FEN: %s", uciMove, currentBoard.ToFEN())
 						break
 					}
+					history = append(history, currentBoard.Hash())
 					currentBoard = newBoard
 				}
 				log.Printf("Reconstructed board from moves. Current FEN: %s", currentBoard.ToFEN())
@@ -590,7 +681,8 @@ func (bot *LichessBot) handleGame(gameID string) {
 			// If it's our turn, make a move
 			if (isWhite && currentBoard.Turn() == White) || (!isWhite && currentBoard.Turn() == Black) {
 				log.Printf("It's our turn! Current FEN: %s", currentBoard.ToFEN())
-				bot.makeBotMove(gameID, currentBoard)
+				tc := botTimeControl(&currentBoard, gameState.WTime, gameState.BTime, gameState.WInc, gameState.BInc)
+				ponder = bot.makeBotMove(gameID, currentBoard, history, skill, tc, ponder)
 			} else {
 				log.Printf("Not our turn. Waiting for opponent's move...")
 			}
@@ -615,37 +707,111 @@ func (bot *LichessBot) handleGame(gameID string) {
 	}
 }
 
-func (bot *LichessBot) makeBotMove(gameID string, board BitBoard) {
+// botTimeControl picks the clock of the side to move. Lichess reports clocks and increments in milliseconds.
+func botTimeControl(board *BitBoard, wtime, btime, winc, binc int) TimeControl {
+	remaining, increment := wtime, winc
+	if board.Turn() == Black {
+		remaining, increment = btime, binc
+	}
+	return TimeControl{
+		Remaining:   time.Duration(remaining) * time.Millisecond,
+		Increment:   time.Duration(increment) * time.Millisecond,
+		MoveNumber:  board.TurnCount(),
+		MaxMoveTime: maxBotMoveTime,
+	}
+}
+
+// botPonder is a search of the position after the opponent's expected reply, run while the opponent thinks.
+type botPonder struct {
+	board  BitBoard
+	cancel context.CancelFunc
+	hit    chan SearchLimits
+	result chan EvaluatedBoard
+}
+
+// startPonder starts pondering on the position after our move and the reply expected by its PV. It returns nil
+// if the PV doesn't predict a reply.
+func (bot *LichessBot) startPonder(gameID string, board BitBoard, history []uint64, skill *Skill, evaluatedBoard EvaluatedBoard) *botPonder {
+	if len(evaluatedBoard.pv) < 2 {
+		return nil
+	}
+	history = append(history, board.Hash())
+	board = evaluatedBoard.board
+	history = append(history, board.Hash())
+	reply := evaluatedBoard.pv[1]
+	board.MakeMove(reply, NewUndoStack())
+	log.Printf("Game %s: pondering on %s", gameID, reply.ToUCI())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ponder := &botPonder{
+		board:  board,
+		cancel: cancel,
+		hit:    make(chan SearchLimits, 1),
+		result: make(chan EvaluatedBoard, 1),
+	}
+	options := bot.searchOptions(gameID, history, skill)
+	options.PonderHit = ponder.hit
+	go func() {
+		ponder.result <- Search(ctx, &board, SearchLimits{Ponder: true}, options)
+	}()
+	return ponder
+}
+
+// stop throws the ponder search away, and waits for it to finish.
+func (p *botPonder) stop() {
+	p.cancel()
+	<-p.result
+}
+
+// searchOptions returns the options for the searches of a game, including ponder searches. Each game gets an
+// equal share of the CPUs.
+func (bot *LichessBot) searchOptions(gameID string, history []uint64, skill *Skill) SearchOptions {
+	return SearchOptions{
+		History:  history,
+		Contempt: botContempt,
+		Skill:    skill,
+		Threads:  max(1, runtime.NumCPU()/max(1, int(bot.activeGames.Load()))),
+		OnInfo: func(info SearchInfo) {
+			log.Printf("Game %s: depth %d seldepth %d score %s nodes %d nps %d time %v pv %s",
+				gameID, info.Depth, info.SelDepth, uciScore(info), info.Nodes, info.NPS, info.Elapsed, info.PVString())
+		},
+	}
+}
+
+// makeBotMove searches and plays a move, and then starts pondering. If the opponent played the move the
+// previous ponder search expected, that search continues as the search for the move. The new ponder search
+// is returned, or nil if there is none.
+func (bot *LichessBot) makeBotMove(gameID string, board BitBoard, history []uint64, skill *Skill, tc TimeControl, ponder *botPonder) *botPonder {
 	log.Printf("Calculating best move for game %s...", gameID)
 	
-	// Get the best move
-	evaluatedBoard := BestMoveWithoutTimeLimit(&board)
+	// Get the best move. Games without a clock fall back to a fixed search time.
+	limits := SearchLimits{Clock: tc}
+	if tc.Remaining <= 0 {
+		limits = SearchLimits{MoveTime: 5 * time.Second}
+	}
+	var evaluatedBoard EvaluatedBoard
+	if ponder != nil && ponder.board.Hash() == board.Hash() {
+		log.Printf("Game %s: ponder hit", gameID)
+		ponder.hit <- limits
+		evaluatedBoard = <-ponder.result
+	} else {
+		if ponder != nil {
+			log.Printf("Game %s: ponder miss", gameID)
+			ponder.stop()
+		}
+		evaluatedBoard = Search(context.Background(), &board, limits, bot.searchOptions(gameID, history, skill))
+	}
 	
-	log.Printf("Best move evaluation: %f", evaluatedBoard.eval)
+	if evaluatedBoard.mate != 0 {
+		log.Printf("Best move evaluation: #%d, pv: %s", evaluatedBoard.mate, evaluatedBoard.info.PVString())
+	} else {
+		log.Printf("Best move evaluation: %f, pv: %s", evaluatedBoard.eval, evaluatedBoard.info.PVString())
+	}
 	
-	// Find the move that transforms current board to the best move board
-	move, found := FindMoveBetweenBoards(&board, &evaluatedBoard.board)
-	if !found {
-		log.Printf("Could not find move between boards in game %s", gameID)
-		log.Printf("From board FEN: %s", board.ToFEN())
-		log.Printf("To board FEN: %s", evaluatedBoard.board.ToFEN())
-		
-		// Fallback: generate all legal moves and pick the first one
-		// This shouldn't happen, but it's a safety net
-		legalMoves, numMoves := GenerateLegalStates(&board)
-		if numMoves > 0 {
-			log.Printf("Fallback: using first legal move out of %d moves", numMoves)
-			// Find the move that leads to the first legal state
-			firstLegalBoard := legalMoves[0]
-			move, found = FindMoveBetweenBoards(&board, &firstLegalBoard)
-			if !found {
-				log.Printf("Even fallback failed! This is unexpected.")
-				return
-			}
-		} else {
-			log.Printf("No legal moves available!")
-			return
-		}
+	move := evaluatedBoard.move
+	if move.bits == 0 {
+		log.Printf("No legal moves available in game %s, FEN: %s", gameID, board.ToFEN())
+		return nil
 	}
 	
 	// Convert to UCI format
@@ -656,9 +822,10 @@ func (bot *LichessBot) makeBotMove(gameID string, board BitBoard) {
 	// Make the move
 	if err := bot.makeMove(gameID, uciMove); err != nil {
 		log.Printf("Error making move %s in game %s: %v", uciMove, gameID, err)
-	} else {
-		log.Printf("Successfully made move %s", uciMove)
+		return nil
 	}
+	log.Printf("Successfully made move %s", uciMove)
+	return bot.startPonder(gameID, board, history, skill, evaluatedBoard)
 }
 
 func (bot *LichessBot) getUserID() string {
@@ -696,6 +863,9 @@ func StartLichessBot(token string) {
 	}
 	
 	bot := NewLichessBot(token)
+	if err := bot.configureStrength(os.Getenv("LICHESS_SKILL_LEVELS"), os.Getenv("LICHESS_RATING_OFFSET")); err != nil {
+		log.Fatal(err)
+	}
 	log.Println("Starting Lichess bot...")
 	
 	// Stream events in a loop (reconnect on error)
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 )
@@ -15,14 +16,40 @@ func main() {
 
 	// Define all flags
 	localFlag := flag.Bool("local", false, "Run local server")
+	uciFlag := flag.Bool("uci", false, "Speak the UCI protocol on stdin/stdout")
+	xboardFlag := flag.Bool("xboard", false, "Speak the XBoard/CECP protocol on stdin/stdout")
 	benchFlag := flag.Bool("bench", false, "Run performance test")
+	perftFlag := flag.Bool("perft", false, "Run the perft suite in resources/perft_answers.csv")
 	cpuprofileFlag := flag.String("cpuprofile", "", "write cpu profile to file")
+	threadsFlag := flag.Int("threads", 0, "Number of search threads for the performance test, 0 for one per CPU")
+	evalFlag := flag.String("eval", "", "Print the evaluation breakdown of a FEN")
 	
 	// Parse flags once
 	flag.Parse()
 	if *benchFlag {
 		log.Println("Running performance test...")
-		PerformanceTest(*cpuprofileFlag)
+		PerformanceTest(*cpuprofileFlag, *threadsFlag)
+		return
+	}
+
+	if *evalFlag != "" {
+		board := BoardFromFEN(*evalFlag)
+		fmt.Print(EvalTrace(&board))
+		return
+	}
+
+	if *perftFlag {
+		Perft()
+		return
+	}
+
+	if *uciFlag {
+		StartUCI()
+		return
+	}
+
+	if *xboardFlag {
+		StartXBoard()
 		return
 	}
 
@@ -0,0 +1,51 @@
+package main
+
+// UndoStack holds the positions before the moves made with MakeMove, so UnmakeMove can restore them.
+// Boards are small, so copying the whole board is cheaper than undoing the move piece by piece.
+type UndoStack struct {
+	boards []BitBoard
+}
+
+func NewUndoStack() *UndoStack {
+	return &UndoStack{boards: make([]BitBoard, 0, maxPly)}
+}
+
+// Len returns the number of moves that can be unmade.
+func (u *UndoStack) Len() int {
+	return len(u.boards)
+}
+
+// MakeMove plays a legal move on the board and pushes the previous position on undo.
+func (b *BitBoard) MakeMove(m Move, undo *UndoStack) {
+	undo.boards = append(undo.boards, *b)
+	*b = transition(b, &m, b.PieceAt(m.Origin()).piece)
+}
+
+// MakeNullMove passes the turn to the opponent without moving a piece, as used by null move pruning.
+// It is taken back with UnmakeMove like any other move.
+func (b *BitBoard) MakeNullMove(undo *UndoStack) {
+	undo.boards = append(undo.boards, *b)
+	key := b.Key ^ zobristEnPassant(b) ^ zobristTurn(b)
+	// Pass the turn, and clear the en passant file. The halfmove clock is reset as well, so no repetition is
+	// detected across the null move.
+	b.Flags ^= uint32(1)
+	b.Flags &^= uint32(0b11110) | uint32(127)<<10
+	b.Key = key ^ zobristTurn(b)
+}
+
+// UnmakeMove takes back the last move made with MakeMove or MakeNullMove.
+func (b *BitBoard) UnmakeMove(undo *UndoStack) {
+	last := len(undo.boards) - 1
+	*b = undo.boards[last]
+	undo.boards = undo.boards[:last]
+}
+
+// lastMoveWasNull reports whether b was reached from the previous position by a null move, which is the only
+// way to pass the turn without changing the pieces on the board.
+func (u *UndoStack) lastMoveWasNull(b *BitBoard) bool {
+	if len(u.boards) == 0 {
+		return false
+	}
+	previous := &u.boards[len(u.boards)-1]
+	return previous.WhiteBB == b.WhiteBB && previous.BlackBB == b.BlackBB
+}
@@ -0,0 +1,174 @@
+package main
+
+import "math/bits"
+
+// Mobility scores in centipawns per safe square a piece attacks, indexed by Piece. A square is safe if it
+// is not occupied by an own piece and not attacked by an enemy pawn. The score is relative to an average
+// number of safe squares, so a piece with few squares is penalized.
+var (
+	mobilityEarly   = [6]int{0, 4, 5, 2, 1, 0}
+	mobilityLate    = [6]int{0, 4, 5, 4, 2, 0}
+	mobilityAverage = [6]int{0, 4, 7, 7, 14, 0}
+)
+
+// Scores in centipawns for the placement of pieces, for the early and the late game.
+const (
+	knightOutpostEarly      = 20
+	knightOutpostLate       = 15
+	bishopOutpostEarly      = 10
+	bishopOutpostLate       = 5
+	bishopPairEarly         = 30
+	bishopPairLate          = 50
+	rookOpenFileEarly       = 25
+	rookOpenFileLate        = 10
+	rookHalfOpenFileEarly   = 12
+	rookHalfOpenFileLate    = 6
+	rookOnSeventhEarly      = 10
+	rookOnSeventhLate       = 25
+	trappedRookEarly        = -45
+	trappedRookLate         = -10
+	trappedBishop           = -100
+	undevelopedMinorByQueen = -8
+)
+
+// Squares from white's point of view. relativeSquare mirrors them for black.
+const (
+	squareA2 = 8
+	squareB3 = 17
+	squareG3 = 22
+	squareH2 = 15
+	squareB1 = 1
+	squareC1 = 2
+	squareD1 = 3
+	squareF1 = 5
+	squareG1 = 6
+)
+
+// mobility scores how many safe squares color's knights, bishops, rooks and queens attack. The attacks come
+// from the same lookups the move generator uses.
+func mobility(board *BitBoard, color Color) (early int, late int) {
+	us := colorBoard(board, color)
+	theirPawns := board.PawnBB & colorBoard(board, color.Opposite())
+	area := ^us &^ pawnAttacks(color.Opposite(), theirPawns)
+	occupied := board.WhiteBB | board.BlackBB
+
+	for piece, pieceBB := range [6]uint64{0, board.KnightBB, board.BishopBB, board.RookBB, board.QueenBB, 0} {
+		for remaining := pieceBB & us; remaining != 0; {
+			square, rest := PopFistBit(remaining)
+			remaining = rest
+			count := bits.OnesCount64(pieceAttacks(Piece(piece), square, occupied)&area) - mobilityAverage[piece]
+			early += count * mobilityEarly[piece]
+			late += count * mobilityLate[piece]
+		}
+	}
+	return early, late
+}
+
+// pieces scores the placement of color's pieces: knight and bishop outposts, the bishop pair, rooks on
+// open files and the 7th rank, pieces that are trapped, and a queen that is developed before the minor pieces.
+func pieces(board *BitBoard, color Color) (early int, late int) {
+	us := colorBoard(board, color)
+	them := colorBoard(board, color.Opposite())
+	ourPawns, theirPawns := board.PawnBB&us, board.PawnBB&them
+	occupied := board.WhiteBB | board.BlackBB
+	king := LSB(board.KingBB & us)
+	theirKing := LSB(board.KingBB & them)
+
+	// An outpost is a square in the enemy half, protected by an own pawn, that no enemy pawn can attack anymore.
+	for minors := (board.KnightBB | board.BishopBB) & us; minors != 0; {
+		square, rest := PopFistBit(minors)
+		minors = rest
+		rank := relativeRank(color, square)
+		if rank < 3 || rank > 5 || pawnAttacks(color, ourPawns)&posToBitBoard(square) == 0 ||
+			theirPawns&passedPawnMasks[color][square]&adjacentFileMasks[square%8] != 0 {
+			continue
+		}
+		if board.KnightBB&posToBitBoard(square) != 0 {
+			early += knightOutpostEarly
+			late += knightOutpostLate
+		} else {
+			early += bishopOutpostEarly
+			late += bishopOutpostLate
+		}
+	}
+
+	if bits.OnesCount64(board.BishopBB&us) >= 2 {
+		early += bishopPairEarly
+		late += bishopPairLate
+	}
+
+	for rooks := board.RookBB & us; rooks != 0; {
+		square, rest := PopFistBit(rooks)
+		rooks = rest
+		file := fileMasks[square%8]
+		switch {
+		case board.PawnBB&file == 0:
+			early += rookOpenFileEarly
+			late += rookOpenFileLate
+		case ourPawns&file == 0:
+			early += rookHalfOpenFileEarly
+			late += rookHalfOpenFileLate
+		}
+
+		// The 7th rank only matters if there are pawns to attack on it, or if it cuts off the enemy king.
+		if relativeRank(color, square) == 6 &&
+			(theirPawns&rankMask(color, 6) != 0 || relativeRank(color, theirKing) == 7) {
+			early += rookOnSeventhEarly
+			late += rookOnSeventhLate
+		}
+
+		// A rook on the first rank that is shut in by its own king, which can't castle to free it anymore.
+		kingFile, rookFile := king%8, square%8
+		kingSide := kingFile >= 4
+		if relativeRank(color, square) == 0 && relativeRank(color, king) == 0 &&
+			kingSide == (rookFile > kingFile) && !canCastle(board, color, kingSide) &&
+			bits.OnesCount64(rookAttacksFrom(square, occupied)&^us) <= 3 {
+			early += trappedRookEarly
+			late += trappedRookLate
+		}
+	}
+
+	// A bishop that took a pawn on a7 or h7 and is shut in by a pawn on b6 or g6.
+	for _, trap := range [2][2]int{{squareA2, squareB3}, {squareH2, squareG3}} {
+		bishop, pawn := relativeSquare(color.Opposite(), trap[0]), relativeSquare(color.Opposite(), trap[1])
+		if board.BishopBB&us&posToBitBoard(bishop) != 0 && theirPawns&posToBitBoard(pawn) != 0 {
+			early += trappedBishop
+			late += trappedBishop
+		}
+	}
+
+	// A queen that leaves its square early gets chased around by the minor pieces developing with tempo.
+	if board.QueenBB&us != 0 && board.QueenBB&us&posToBitBoard(relativeSquare(color, squareD1)) == 0 {
+		for _, square := range [4]int{squareB1, squareC1, squareF1, squareG1} {
+			if (board.KnightBB|board.BishopBB)&us&posToBitBoard(relativeSquare(color, square)) != 0 {
+				early += undevelopedMinorByQueen
+			}
+		}
+	}
+	return early, late
+}
+
+func canCastle(board *BitBoard, color Color, kingSide bool) bool {
+	switch {
+	case color == White && kingSide:
+		return board.WhiteCanCastleKingSite()
+	case color == White:
+		return board.WhiteCanCastleQueenSite()
+	case kingSide:
+		return board.BlackCanCastleKingSite()
+	}
+	return board.BlackCanCastleQueenSite()
+}
+
+// relativeSquare mirrors a square given from white's point of view to color's point of view.
+func relativeSquare(color Color, square int) int {
+	if color == White {
+		return square
+	}
+	return square ^ 56
+}
+
+// rankMask returns the squares on a rank as seen from color's side of the board.
+func rankMask(color Color, rank int) uint64 {
+	return uint64(0xFF) << relativeSquare(color, rank*8)
+}
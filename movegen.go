@@ -13,145 +13,272 @@ var rookMagics = RookMagics()
 var rookBits = RookBits()
 var rookMoveTable = RookMoveTable()
 
-// Move tables
-var kingMovesMapping = KingMovesMapping()
-var whitePawnAttackMoves = WhitePawnAttackMovesMapping()
-var whitePawnDoubleMoves = WhitePawnDoubleMovesMapping()
-var whitePawnStraightMoves = WhitePawnStraightMovesMapping()
-var whiteEnPassantMoves = WhiteEnPassantMovesMapping()
-var blackPawnAttackMoves = BlackPawnAttackMovesMapping()
-var blackPawnDoubleMoves = BlackPawnDoubleMovesMapping()
-var blackPawnStraightMoves = BlackPawnStraightMovesMapping()
-var blackEnPassantMoves = BlackEnPassantMovesMapping()
-var knightMovesMapping = KnightMovesMapping()
+var bishopAttackTable = MagicAttackTable(bishopMoveTable, bishopBits)
+var rookAttackTable = MagicAttackTable(rookMoveTable, rookBits)
+var betweenMasks, lineMasks = BetweenAndLineMasks()
 
 var knightMasks = KnightMasks()
 var kingMasks = KingMasks()
 var whitePawnAttackMasks = WhitePawnAttackMasks()
 var blackPawnAttackMasks = BlackPawnAttackMasks()
 
+// Rank masks
+const rank1 = uint64(0x00000000000000ff)
+const rank2 = uint64(0x000000000000ff00)
+const rank7 = uint64(0x00ff000000000000)
+const rank8 = uint64(0xff00000000000000)
+
 // Castling empty square checks
 var wqSideCastleInMask = posToBitBoard(1) | posToBitBoard(2) | posToBitBoard(3) 
 var wkCastleInMask = posToBitBoard(5) | posToBitBoard(6) 
 var bqSideCastleInMask = posToBitBoard(57) | posToBitBoard(58) | posToBitBoard(59) 
 var bkSideCastleInMask = posToBitBoard(61) | posToBitBoard(62)
 
+// Castling squares that may not be attacked: where the king starts, passes and lands
+var wqCastleKingPath = posToBitBoard(2) | posToBitBoard(3) | posToBitBoard(4)
+var wkCastleKingPath = posToBitBoard(4) | posToBitBoard(5) | posToBitBoard(6)
+var bqCastleKingPath = posToBitBoard(58) | posToBitBoard(59) | posToBitBoard(60)
+var bkCastleKingPath = posToBitBoard(60) | posToBitBoard(61) | posToBitBoard(62)
+
 var wqCastleMove = Move{bits: uint32(0xC102)}
 var wkCastleMove = Move{bits: uint32(0xC106)}
 var bkCastleMove = Move{bits: uint32(0xCF3E)}
 var bqCastleMove = Move{bits: uint32(0xCF3A)}
 
-func GenerateLegalStates(b *BitBoard) ([80]BitBoard, int) {
-	// Most positions have 20-30 moves, rarely exceeding 60. Using 80 covers 99.99% of cases
-	// while avoiding unnecessary zeroing of 120 extra slots (saves ~8.6KB stack space).
-	var nextStates [80]BitBoard
-	kings := b.KingBB & b.TurnBoard()
-	if kings <= 0 {
-		return [80]BitBoard{}, 0
+// GenerateLegalStates returns the positions after each of the legal moves.
+func GenerateLegalStates(b *BitBoard) []BitBoard {
+	var moves MoveList
+	GenerateMoves(b, GenAll, &moves)
+	states := make([]BitBoard, moves.Count)
+	for i, m := range moves.Slice() {
+		states[i] = transition(b, &m, b.PieceAt(m.Origin()).piece)
 	}
-	currentKingPos, _ := PopFistBit(kings)
-
-	pawnMoves := pawnMoves(b)
-	knightMoves := knightMoves(b)
-	bishopMoves := bishopMoves(b)
-	rookMoves := rookMoves(b)
-	queenMoves := queenMoves(b)
-	kingMoves := kingMoves(b)
-	castlingMoves := castlingMoves(b)
+	return states
+}
 
-	i := 0
-	j := 0
-	m := pawnMoves[j]
-	for m.bits > 0 {
-		nextState := transition(b, &m, Pawn)
-		if !isChecked(&nextState, currentKingPos, nextState.Turn()) {
-			nextStates[i] = nextState
-			i++
+// GenerateMoves fills list with the legal moves of the side to move that belong to mode. Checkers and pinned
+// pieces are found up front, so only en passant captures have to be played to see if they leave the king in check.
+func GenerateMoves(b *BitBoard, mode GenMode, list *MoveList) {
+	list.Count = 0
+	us, them := b.TurnBoard(), b.OppositeTurnBoard()
+	kings := b.KingBB & us
+	if kings == 0 {
+		return
+	}
+	kingPos, _ := PopFistBit(kings)
+	occupied := us | them
+
+	var targets uint64
+	switch mode {
+	case GenCaptures:
+		targets = them
+	case GenQuiet:
+		targets = ^occupied
+	default:
+		targets = ^us
+	}
+
+	// The king is lifted off the board, so it can't step back along the ray of a slider that checks it.
+	kingTargets := kingMasks[kingPos] & targets
+	for kingTargets > 0 {
+		destination, rest := PopFistBit(kingTargets)
+		kingTargets = rest
+		if attackersTo(b, destination, occupied&^kings)&them == 0 {
+			list.Add(newMove(kingPos, destination, 0))
+		}
+	}
+
+	checkers := attackersTo(b, kingPos, occupied) & them
+	if checkers&(checkers-1) != 0 {
+		// Double check. Only the king can move.
+		return
+	}
+	// When in check, the other pieces have to capture the checker or block the check.
+	evasionMask := ^uint64(0)
+	if checkers != 0 {
+		evasionMask = checkers | betweenMasks[kingPos][LSB(checkers)]
+	}
+	pinned := pinnedPieces(b, kingPos)
+
+	generatePawnMoves(b, mode, list, kingPos, evasionMask, pinned)
+
+	// A pinned piece can only move along the line between its king and the pinning piece.
+	addPieceMoves := func(pieces uint64, attacks func(origin int) uint64) {
+		for pieces > 0 {
+			origin, rest := PopFistBit(pieces)
+			pieces = rest
+			destinations := attacks(origin) & targets & evasionMask
+			if pinned&posToBitBoard(origin) != 0 {
+				destinations &= lineMasks[kingPos][origin]
+			}
+			for destinations > 0 {
+				destination, rest := PopFistBit(destinations)
+				destinations = rest
+				list.Add(newMove(origin, destination, 0))
+			}
 		}
-		j++
-		m = pawnMoves[j]
 	}
+	addPieceMoves(b.KnightBB&us, func(origin int) uint64 {
+		return knightMasks[origin]
+	})
+	addPieceMoves((b.BishopBB|b.QueenBB)&us, func(origin int) uint64 {
+		return bishopAttacksFrom(origin, occupied)
+	})
+	addPieceMoves((b.RookBB|b.QueenBB)&us, func(origin int) uint64 {
+		return rookAttacksFrom(origin, occupied)
+	})
 
-	j = 0
-	m = knightMoves[j]
-	for m.bits > 0 {
-		nextState := transition(b, &m, Knight)
-		if !isChecked(&nextState, currentKingPos, nextState.Turn()) {
-			nextStates[i] = nextState
-			i++
-		}
-		j++
-		m = knightMoves[j]
-	}
-	j = 0
-	m = bishopMoves[j]
-	for m.bits > 0 {
-		nextState := transition(b, &m, Bishop)
-		if !isChecked(&nextState, currentKingPos, nextState.Turn()) {
-			nextStates[i] = nextState
-			i++
-		}
-		j++
-		m = bishopMoves[j]
-	}
-	j = 0
-	m = rookMoves[j]
-	for m.bits > 0 {
-		nextState := transition(b, &m, Rook)
-		if !isChecked(&nextState, currentKingPos, nextState.Turn()) {
-			nextStates[i] = nextState
-			i++
+	// Castling is quiet, and never gets the king out of check.
+	if checkers == 0 && (mode == GenAll || mode == GenQuiet) {
+		for _, m := range castlingMoves(b) {
+			if m.bits > 0 {
+				list.Add(m)
+			}
 		}
-		j++
-		m = rookMoves[j]
-	}
-	j = 0
-	m = queenMoves[j]
-	for m.bits > 0 {
-		nextState := transition(b, &m, Queen)
-		if !isChecked(&nextState, currentKingPos, nextState.Turn()) {
-			nextStates[i] = nextState
-			i++
+	}
+}
+
+func generatePawnMoves(b *BitBoard, mode GenMode, list *MoveList, kingPos int, evasionMask uint64, pinned uint64) {
+	us, them := b.TurnBoard(), b.OppositeTurnBoard()
+	occupied := us | them
+
+	forward := 8
+	startRank, lastRank := rank2, rank8
+	attackMasks, enemyAttackMasks := &whitePawnAttackMasks, &blackPawnAttackMasks
+	if b.Turn() == Black {
+		forward = -8
+		startRank, lastRank = rank7, rank1
+		attackMasks, enemyAttackMasks = &blackPawnAttackMasks, &whitePawnAttackMasks
+	}
+
+	pawns := b.PawnBB & us
+	for pawns > 0 {
+		origin, rest := PopFistBit(pawns)
+		pawns = rest
+		allowed := evasionMask
+		if pinned&posToBitBoard(origin) != 0 {
+			allowed &= lineMasks[kingPos][origin]
+		}
+
+		push := origin + forward
+		if posToBitBoard(push)&occupied == 0 {
+			if posToBitBoard(push)&lastRank != 0 {
+				if mode != GenQuiet && posToBitBoard(push)&allowed != 0 {
+					addPromotions(list, origin, push)
+				}
+			} else if mode != GenCaptures {
+				if posToBitBoard(push)&allowed != 0 {
+					list.Add(newMove(origin, push, 0))
+				}
+				doublePush := push + forward
+				if posToBitBoard(origin)&startRank != 0 && posToBitBoard(doublePush)&(occupied|^allowed) == 0 {
+					list.Add(newMove(origin, doublePush, doublePawnMoveBits))
+				}
+			}
 		}
-		j++
-		m = queenMoves[j]
-	}
-	j = 0
-	m = kingMoves[j]
-	for m.bits > 0 {
-		nextState := transition(b, &m, King)
-		// Since the king move, we'll have to use the next position when looking for checks.
-		newKingPos := m.Destination()
-		if !isChecked(&nextState, newKingPos, nextState.Turn()) {
-			nextStates[i] = nextState
-			i++
+
+		if mode == GenQuiet {
+			continue
 		}
-		j++
-		if j < len(kingMoves) {
-			m = kingMoves[j]
-		} else {
-			m = Move{0}
+		captures := attackMasks[origin] & them & allowed
+		for captures > 0 {
+			destination, rest := PopFistBit(captures)
+			captures = rest
+			if posToBitBoard(destination)&lastRank != 0 {
+				addPromotions(list, origin, destination)
+			} else {
+				list.Add(newMove(origin, destination, 0))
+			}
 		}
 	}
 
-	j = 0
-	m = castlingMoves[j]
-	for m.bits > 0 {
-		nextState := transition(b, &m, King)
-		newKingPos := m.Destination()
-		if !isChecked(&nextState, newKingPos, nextState.Turn()) {
-			nextStates[i] = nextState
-			i++
+	file := b.DoublePawnMoveFile()
+	if mode == GenQuiet || file < 1 {
+		return
+	}
+	destination := 40 + file - 1
+	if b.Turn() == Black {
+		destination = 16 + file - 1
+	}
+	// En passant removes two pawns from the same rank, which can expose the king in ways the pin detection
+	// doesn't see. It's rare enough to simply play the move and look.
+	attackers := enemyAttackMasks[destination] & b.PawnBB & us
+	for attackers > 0 {
+		origin, rest := PopFistBit(attackers)
+		attackers = rest
+		m := newMove(origin, destination, enPassantMoveBits)
+		next := transition(b, &m, Pawn)
+		if !isChecked(&next, kingPos, b.OppositeTurn()) {
+			list.Add(m)
 		}
-		j++
-		if j < len(castlingMoves) {
-			m = castlingMoves[j]
-		} else {
-			m = Move{0}
+	}
+}
+
+// addPromotions adds the four promotions of a pawn move, with the queen first.
+func addPromotions(list *MoveList, origin int, destination int) {
+	for promotion := uint32(3); promotion <= 3; promotion-- {
+		list.Add(newMove(origin, destination, promotionBits|promotion<<12))
+	}
+}
+
+// attackersTo returns the pieces of both colors that attack square when the board is occupied by occupied.
+func attackersTo(b *BitBoard, square int, occupied uint64) uint64 {
+	return blackPawnAttackMasks[square]&b.PawnBB&b.WhiteBB |
+		whitePawnAttackMasks[square]&b.PawnBB&b.BlackBB |
+		knightMasks[square]&b.KnightBB |
+		kingMasks[square]&b.KingBB |
+		bishopAttacksFrom(square, occupied)&(b.BishopBB|b.QueenBB) |
+		rookAttacksFrom(square, occupied)&(b.RookBB|b.QueenBB)
+}
+
+// pieceAttacks returns the squares attacked by a knight, bishop, rook or queen.
+func pieceAttacks(piece Piece, square int, occupied uint64) uint64 {
+	switch piece {
+	case Knight:
+		return knightMasks[square]
+	case Bishop:
+		return bishopAttacksFrom(square, occupied)
+	case Rook:
+		return rookAttacksFrom(square, occupied)
+	case Queen:
+		return bishopAttacksFrom(square, occupied) | rookAttacksFrom(square, occupied)
+	}
+	return 0
+}
+
+// pinnedPieces returns the pieces of the side to move that are the only piece between their king and
+// an enemy slider.
+func pinnedPieces(b *BitBoard, kingPos int) uint64 {
+	us, them := b.TurnBoard(), b.OppositeTurnBoard()
+	occupied := us | them
+	snipers := (rookAttacksFrom(kingPos, 0)&(b.RookBB|b.QueenBB) | bishopAttacksFrom(kingPos, 0)&(b.BishopBB|b.QueenBB)) & them
+
+	var pinned uint64
+	for snipers > 0 {
+		sniper, rest := PopFistBit(snipers)
+		snipers = rest
+		blockers := betweenMasks[kingPos][sniper] & occupied
+		if blockers != 0 && blockers&(blockers-1) == 0 && blockers&us != 0 {
+			pinned |= blockers
 		}
 	}
+	return pinned
+}
+
+func bishopAttacksFrom(square int, occupied uint64) uint64 {
+	blockers := magicBishopMasks[square] & occupied
+	return bishopAttackTable[square][(blockers*bishopMagics[square])>>(64-bishopBits[square])]
+}
+
+func rookAttacksFrom(square int, occupied uint64) uint64 {
+	blockers := magicRookMasks[square] & occupied
+	return rookAttackTable[square][(blockers*rookMagics[square])>>(64-rookBits[square])]
+}
 
-	return nextStates, i
+// InCheck reports whether the king of the side to move is attacked.
+func (b *BitBoard) InCheck() bool {
+	kingPos, _ := PopFistBit(b.KingBB & b.TurnBoard())
+	return kingPos >= 0 && isChecked(b, kingPos, b.OppositeTurn())
 }
 
 func isChecked(board *BitBoard, kingPos int, kingColor Color) bool {
@@ -175,10 +302,7 @@ func isChecked(board *BitBoard, kingPos int, kingColor Color) bool {
 	if bishopMasksWithEdges[kingPos]&bishopsAndQueens&turnBoard > 0 {
 		// The move resulted in a piece being moved from the diagonal where an opposing bishop can attack
 		// the king. We need to see if it is a discovery check.
-		blockers := magicBishopMasks[kingPos] & (board.WhiteBB | board.BlackBB)
-		magic := bishopMagics[kingPos]
-		key := int((blockers * magic) >> (64 - bishopBits[kingPos]))
-		bishopAttackMask := bishopMoveTable[MagicKey{Square: kingPos, Key: key}]
+		bishopAttackMask := bishopAttacksFrom(kingPos, board.WhiteBB|board.BlackBB)
 		isCheckByBishopOrQueen = bishopAttackMask&bishopsAndQueens&turnBoard > 0
 		if isCheckByBishopOrQueen {
 			return true
@@ -187,10 +311,7 @@ func isChecked(board *BitBoard, kingPos int, kingColor Color) bool {
 	rooksAndQueens := board.RookBB | board.QueenBB
 	if rookMasksWithEdges[kingPos]&rooksAndQueens&turnBoard > 0 {
 		// Same as above, just with rooks instead
-		blockers := magicRookMasks[kingPos] & (board.WhiteBB | board.BlackBB)
-		magic := rookMagics[kingPos]
-		key := int((blockers * magic) >> (64 - rookBits[kingPos]))
-		rookAttackMask := rookMoveTable[MagicKey{Square: kingPos, Key: key}]
+		rookAttackMask := rookAttacksFrom(kingPos, board.WhiteBB|board.BlackBB)
 		isCheckByRookOrQueen = rookAttackMask&rooksAndQueens&turnBoard > 0
 		if isCheckByRookOrQueen {
 			return true
@@ -253,8 +374,17 @@ func transition(b *BitBoard, m *Move, piece Piece) BitBoard {
 	// Clear previous double pawn move flag
 	flags &^= uint32(0b11110)
 
-	flags &^= uint32(0b111111111111111) << 17
-	flags |= uint32(b.TurnCount()+1) << 17
+	// The move counter is incremented after black has moved.
+	if b.Turn() == Black {
+		flags &^= uint32(0b111111111111111) << 17
+		flags |= uint32(b.TurnCount()+1) << 17
+	}
+
+	// The halfmove clock is reset by captures and pawn moves. It saturates at the largest value that fits.
+	flags &^= uint32(127) << 10
+	if piece != Pawn && capturedPiece == Empty {
+		flags |= uint32(min(b.HalfMoveClock()+1, 127)) << 10
+	}
 
 	if m.IsDoublePawnMove() {
 		dpfile := (m.Destination() % 8) + 1
@@ -275,11 +405,11 @@ func transition(b *BitBoard, m *Move, piece Piece) BitBoard {
 		if b.Turn() == White {
 			whiteBB = (b.WhiteBB | posToBitBoard(40+enPassantFile-1)) &^ originBB
 			blackBB = b.BlackBB &^ posToBitBoard(32+enPassantFile-1)
-			pawnBB = b.PawnBB | posToBitBoard(40+enPassantFile-1)&^originBB&^posToBitBoard(32+enPassantFile-1)
+			pawnBB = (b.PawnBB | posToBitBoard(40+enPassantFile-1)) &^ originBB &^ posToBitBoard(32+enPassantFile-1)
 		} else {
 			blackBB = (b.BlackBB | posToBitBoard(16+enPassantFile-1)) &^ originBB
 			whiteBB = b.WhiteBB &^ posToBitBoard(24+enPassantFile-1)
-			pawnBB = b.PawnBB | posToBitBoard(16+enPassantFile-1)&^originBB&^posToBitBoard(24+enPassantFile-1)
+			pawnBB = (b.PawnBB | posToBitBoard(16+enPassantFile-1)) &^ originBB &^ posToBitBoard(24+enPassantFile-1)
 		}
 	} else {
 		if b.Turn() == White {
@@ -299,28 +429,33 @@ func transition(b *BitBoard, m *Move, piece Piece) BitBoard {
 			rookBB |= posToBitBoard(3)
 			whiteBB &^= posToBitBoard(0)
 			whiteBB |= posToBitBoard(3)
-			flags &^= uint32(0x60)
 		} else if m.Destination() == 6 {
 			// White king side
 			rookBB &^= posToBitBoard(7)
 			rookBB |= posToBitBoard(5)
 			whiteBB &^= posToBitBoard(7)
 			whiteBB |= posToBitBoard(5)
-			flags &^= uint32(0x60)
 		} else if m.Destination() == 58 {
 			// Black queen side
 			rookBB &^= posToBitBoard(56)
 			rookBB |= posToBitBoard(59)
 			blackBB &^= posToBitBoard(56)
 			blackBB |= posToBitBoard(59)
-			flags &^= uint32(0x180)
 		} else {
 			// Black king side
 			rookBB &^= posToBitBoard(63)
 			rookBB |= posToBitBoard(61)
 			blackBB &^= posToBitBoard(63)
 			blackBB |= posToBitBoard(61)
-			flags &^= uint32(0x180)
+		}
+	}
+
+	// Any king move, castling included, gives up both castling rights.
+	if piece == King {
+		if b.Turn() == White {
+			flags &^= uint32(0xC0)
+		} else {
+			flags &^= uint32(0x300)
 		}
 	}
 
@@ -358,6 +493,22 @@ func transition(b *BitBoard, m *Move, piece Piece) BitBoard {
 	queenBB = moveOrPass(Queen, queenBB)
 	kingBB = moveOrPass(King, kingBB)
 
+	// The pawn has been moved to the last rank above. Replace it with the promoted piece.
+	switch m.Promotion() {
+	case Knight:
+		pawnBB &^= destinationBB
+		knightBB |= destinationBB
+	case Bishop:
+		pawnBB &^= destinationBB
+		bishopBB |= destinationBB
+	case Rook:
+		pawnBB &^= destinationBB
+		rookBB |= destinationBB
+	case Queen:
+		pawnBB &^= destinationBB
+		queenBB |= destinationBB
+	}
+
 	res := BitBoard{
 		WhiteBB:  whiteBB,
 		BlackBB:  blackBB,
@@ -369,280 +520,86 @@ func transition(b *BitBoard, m *Move, piece Piece) BitBoard {
 		KingBB:   kingBB,
 		Flags:    flags,
 	}
+	res.Key = b.Key ^ zobristMoveDelta(b, &res, m, piece, capturedPiece)
 	return res
 }
 
-func kingMoves(bb *BitBoard) [8]Move {
-	var validMoves [8]Move
-	kings := bb.KingBB & bb.TurnBoard()
-	i := 0
-	for kings > 0 {
-		pos, newKings := PopFistBit(kings)
-		kings = newKings
-		moves := kingMovesMapping[pos]
-		for _, m := range moves {
-			if isNotSelfCapture(bb, &m) {
-				validMoves[i] = m
-				i++
-			}
-		}
-	}
-	return validMoves
-}
-
-func pawnMoves(bb *BitBoard) [32]Move {
-	var validMoves [32]Move
-	pawns := bb.PawnBB & bb.TurnBoard()
-	i := 0
-	for pawns > 0 {
-		pos, newPawns := PopFistBit(pawns)
-		pawns = newPawns
-		moves := pawnMovesFromPos(bb, pos)
-		for j := 0; j < len(moves); j++ {
-			if moves[j].bits == 0 {
-				break // Once we find a 0 move we can stop
-			}
-			validMoves[i] = moves[j]
-			i++
-		}
-	}
-	return validMoves
-}
-
-func pawnMovesFromPos(bb *BitBoard, origin int) [12]Move {
-	// Should never be possible to generate more than 12 pawn moves (including different promotion options in all directions) from one single pos.
-	var validMoves [12]Move
-	var straight, double, attack, enPassant []Move
-
-	if bb.Turn() == White {
-		straight = whitePawnStraightMoves[origin]
-		double = whitePawnDoubleMoves[origin]
-		attack = whitePawnAttackMoves[origin]
-		if file := bb.DoublePawnMoveFile(); file > 0 && file <= 8 {
-			enPassant = whiteEnPassantMoves[origin]
-		}
-	} else {
-		straight = blackPawnStraightMoves[origin]
-		double = blackPawnDoubleMoves[origin]
-		attack = blackPawnAttackMoves[origin]
-		if file := bb.DoublePawnMoveFile(); file > 0 && file <= 8 {
-			enPassant = blackEnPassantMoves[origin]
-		}
-	}
+// zobristMoveDelta returns what has to be XOR-ed into the key of b to get the key of next, given the move
+// between them. Only the squares and flags touched by the move are hashed.
+func zobristMoveDelta(b *BitBoard, next *BitBoard, m *Move, piece Piece, capturedPiece Piece) uint64 {
+	us, them := b.Turn(), b.OppositeTurn()
+	origin, destination := m.Origin(), m.Destination()
 
-	// These are used to see if a piece is blocking the destinations of the pawn.
-	var straightOffset, doubleStraightOffset int
-	if bb.Turn() == White {
-		straightOffset = 8
-		doubleStraightOffset = 16
+	delta := zobristPiece(us, piece, origin)
+	if promotion := m.Promotion(); promotion != Empty {
+		delta ^= zobristPiece(us, promotion, destination)
 	} else {
-		straightOffset = -8
-		doubleStraightOffset = -16
-	}
-
-	nextSquareIsEmpty := bb.IsEmpty(origin + straightOffset)
-
-	i := 0
-	for _, m := range straight {
-		if nextSquareIsEmpty {
-			validMoves[i] = m
-			i++
-		}
-	}
-	for _, m := range double {
-		if nextSquareIsEmpty && bb.IsEmpty(origin+doubleStraightOffset) {
-			validMoves[i] = m
-			i++
-		}
-	}
-	for _, m := range attack {
-		if isNotSelfCapture(bb, &m) && isCapture(bb, &m) {
-			validMoves[i] = m
-			i++
-		}
-	}
-	for _, m := range enPassant {
-		if isValidEnPassantCapture(bb, &m) {
-			validMoves[i] = m
-			i++
-		}
+		delta ^= zobristPiece(us, piece, destination)
 	}
-
-	return validMoves
-}
-
-func knightMoves(bb *BitBoard) [32]Move {
-	var validMoves [32]Move
-	i := 0
-	knights := bb.KnightBB & bb.TurnBoard()
-	for knights > 0 {
-		pos, newKnights := PopFistBit(knights)
-		knights = newKnights
-		for _, m := range knightMovesFromPos(bb, pos) {
-			if m.bits > 0 {
-				validMoves[i] = m
-				i++
-			}
-		}
-	}
-	return validMoves
-}
-
-func knightMovesFromPos(bb *BitBoard, origin int) [8]Move {
-	var validMoves [8]Move
-	i := 0
-	for _, move := range knightMovesMapping[origin] {
-		if isNotSelfCapture(bb, &move) {
-			validMoves[i] = move
-			i++
-		}
+	if capturedPiece != Empty {
+		delta ^= zobristPiece(them, capturedPiece, destination)
 	}
-	return validMoves
-}
-
-func bishopMoves(bb *BitBoard) [50]Move {
-	var validMoves [50]Move
-	bishops := bb.BishopBB & bb.TurnBoard()
-	i := 0
-	for bishops > 0 {
-		pos, newBishops := PopFistBit(bishops)
-		bishops = newBishops
-		for _, move := range bishopMovesFromPos(bb, pos) {
-			if isNotSelfCapture(bb, &move) {
-				if move.bits > 0 {
-					validMoves[i] = move
-					i++
-				}
-			}
+	if m.IsEnPassantMove() {
+		if us == White {
+			delta ^= zobristPiece(them, Pawn, destination-8)
+		} else {
+			delta ^= zobristPiece(them, Pawn, destination+8)
 		}
 	}
-	return validMoves
-}
-
-func bishopMovesFromPos(bb *BitBoard, origin int) [13]Move {
-	var moves [13]Move
-	mask := magicBishopMasks[origin]
-	blockers := mask & (bb.WhiteBB | bb.BlackBB)
-	magic := bishopMagics[origin]
-	key := int((blockers * magic) >> (64 - bishopBits[origin]))
-	legalSquares := bishopMoveTable[MagicKey{Square: origin, Key: key}]
-	i := 0
-	for legalSquares > 0 {
-		destination, newSquares := PopFistBit(legalSquares)
-		legalSquares = newSquares
-		destinationBits := uint32(destination)
-		originBits := uint32(origin) << 6
-		flagBits := uint32(0) << 12
-
-		move := Move{destinationBits | originBits | flagBits}
-		moves[i] = move
-		i++
-	}
-	return moves
-}
-
-func rookMoves(bb *BitBoard) [50]Move {
-	var validMoves [50]Move
-	rooks := bb.RookBB & bb.TurnBoard()
-	i := 0
-	for rooks > 0 {
-		pos, newRooks := PopFistBit(rooks)
-		rooks = newRooks
-		for _, move := range rookMovesFromPos(bb, pos) {
-			if isNotSelfCapture(bb, &move) {
-				if move.bits > 0 {
-					validMoves[i] = move
-					i++
-				}
-			}
+	if m.IsCastleMove() {
+		switch destination {
+		case 2:
+			delta ^= zobristPiece(us, Rook, 0) ^ zobristPiece(us, Rook, 3)
+		case 6:
+			delta ^= zobristPiece(us, Rook, 7) ^ zobristPiece(us, Rook, 5)
+		case 58:
+			delta ^= zobristPiece(us, Rook, 56) ^ zobristPiece(us, Rook, 59)
+		case 62:
+			delta ^= zobristPiece(us, Rook, 63) ^ zobristPiece(us, Rook, 61)
 		}
 	}
-	return validMoves
-}
 
-func rookMovesFromPos(bb *BitBoard, origin int) [14]Move {
-	var moves [14]Move
-	mask := magicRookMasks[origin]
-	blockers := mask & (bb.WhiteBB | bb.BlackBB)
-	magic := rookMagics[origin]
-	key := int((blockers * magic) >> (64 - rookBits[origin]))
-	legalSquares := rookMoveTable[MagicKey{Square: origin, Key: key}]
-	i := 0
-	for legalSquares > 0 {
-		destination, newSquares := PopFistBit(legalSquares)
-		legalSquares = newSquares
-		destinationBits := uint32(destination)
-		originBits := uint32(origin) << 6
-		flagBits := uint32(0) << 12
-
-		move := Move{destinationBits | originBits | flagBits}
-		moves[i] = move
-		i += 1
-	}
-	return moves
-}
-
-func queenMoves(bb *BitBoard) [100]Move {
-	var validMoves [100]Move
-	queens := bb.QueenBB & bb.TurnBoard()
-	i := 0
-	for queens > 0 {
-		origin, newQueens := PopFistBit(queens)
-		queens = newQueens
-		rook := rookMovesFromPos(bb, origin)
-		bishop := bishopMovesFromPos(bb, origin)
-		for _, move := range rook {
-			if isNotSelfCapture(bb, &move) {
-				if move.bits > 0 {
-					validMoves[i] = move
-					i++
-				}
-			}
-		}
-		for _, move := range bishop {
-			if isNotSelfCapture(bb, &move) {
-				validMoves[i] = move
-				i++
-			}
-		}
-	}
-	return validMoves
+	delta ^= zobristCastling(b.Flags) ^ zobristCastling(next.Flags)
+	delta ^= zobristEnPassant(b) ^ zobristEnPassant(next)
+	delta ^= zobristTurn(b) ^ zobristTurn(next)
+	return delta
 }
 
+// castlingMoves returns the legal castling moves. The king may not castle out of, through or into check,
+// but on the queen side the rook may pass an attacked square.
 func castlingMoves(bb *BitBoard) [2]Move {
-	canCastle := func(inBetweenSquares uint64) bool {
-		if (bb.WhiteBB|bb.BlackBB)&inBetweenSquares == 0 {
-			for inBetweenSquares > 0 {
-				square, sqs := PopFistBit(inBetweenSquares)
-				inBetweenSquares = sqs
-				if isChecked(bb, square, bb.OppositeTurn()) {
-					return false
-				}
-			}
-			return true
-		} else {
+	canCastle := func(inBetweenSquares uint64, kingPath uint64) bool {
+		if (bb.WhiteBB|bb.BlackBB)&inBetweenSquares != 0 {
 			return false
 		}
+		for kingPath > 0 {
+			square, sqs := PopFistBit(kingPath)
+			kingPath = sqs
+			if isChecked(bb, square, bb.OppositeTurn()) {
+				return false
+			}
+		}
+		return true
 	}
 	var validMoves [2]Move
 	i := 0
 	if bb.Turn() == White {
 		correctKingPos := bb.KingBB&bb.WhiteBB&posToBitBoard(4) > 0
-		if correctKingPos && bb.WhiteCanCastleKingSite() && canCastle(wkCastleInMask) {
+		if correctKingPos && bb.WhiteCanCastleKingSite() && canCastle(wkCastleInMask, wkCastleKingPath) {
 			validMoves[i] = wkCastleMove
 			i++
 		}
-		if correctKingPos && bb.WhiteCanCastleQueenSite() && canCastle(wqSideCastleInMask) {
+		if correctKingPos && bb.WhiteCanCastleQueenSite() && canCastle(wqSideCastleInMask, wqCastleKingPath) {
 			validMoves[i] = wqCastleMove
 			i++
 		}
 	} else {
 		correctKingPos := bb.KingBB&bb.BlackBB&posToBitBoard(60) > 0
-		if correctKingPos && bb.BlackCanCastleKingSite() && canCastle(bkSideCastleInMask) {
+		if correctKingPos && bb.BlackCanCastleKingSite() && canCastle(bkSideCastleInMask, bkCastleKingPath) {
 			validMoves[i] = bkCastleMove
 			i++
 		}
-		if correctKingPos && bb.BlackCanCastleQueenSite() && canCastle(bqSideCastleInMask) {
+		if correctKingPos && bb.BlackCanCastleQueenSite() && canCastle(bqSideCastleInMask, bqCastleKingPath) {
 			validMoves[i] = bqCastleMove
 			i++
 		}
@@ -650,19 +607,11 @@ func castlingMoves(bb *BitBoard) [2]Move {
 	return validMoves
 }
 
-func isNotSelfCapture(bb *BitBoard, m *Move) bool {
-	return bb.TurnBoard()&posToBitBoard(m.Destination()) == 0
+// isTactical reports whether the move changes the material balance, i.e. is a capture or a promotion.
+func isTactical(bb *BitBoard, m *Move) bool {
+	return isCapture(bb, m) || m.IsEnPassantMove() || m.IsPromotion()
 }
 
 func isCapture(bb *BitBoard, m *Move) bool {
 	return bb.OppositeTurnBoard()&posToBitBoard(m.Destination()) > 0
 }
-
-func isValidEnPassantCapture(bb *BitBoard, m *Move) bool {
-	destinationFile := (m.Destination() % 8) + 1
-	if bb.DoublePawnMoveFile() == destinationFile {
-		return true
-	} else {
-		return false
-	}
-}
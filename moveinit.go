@@ -1,6 +1,8 @@
 package main
 
 import (
+	"embed"
+	"log"
 	"math"
 	"strconv"
 	"strings"
@@ -8,23 +10,21 @@ import (
 
 var kingOffsets = [][2]int{{-1, -1}, {0, -1}, {1, -1}, {-1, 0}, {1, 0}, {-1, 1}, {0, 1}, {1, 1}}
 var knightOffsets = [][2]int{{-2, -1}, {-2, 1}, {-1, -2}, {-1, 2}, {1, -2}, {1, 2}, {2, -1}, {2, 1}}
-var whitePawnStraightOffsets = [][2]int{{1, 0}}
-var whitePawnDoubleOffsets = [][2]int{{2, 0}}
 var whitePawnAttackOffsets = [][2]int{{1, -1}, {1, 1}}
-var blackPawnStraightOffsets = [][2]int{{-1, 0}}
-var blackPawnDoubleOffsets = [][2]int{{-2, 0}}
 var blackPawnAttackOffsets = [][2]int{{-1, -1}, {-1, 1}}
 
 // Magic move gen
 
+// The magic numbers and move tables written by magicgen.go are built into the binary, so the engine finds
+// them no matter which directory it is started from.
+//
+//go:embed resources/move_tables
+var moveTables embed.FS
+
 type MagicKey struct {
 	Square, Key int
 }
 
-var promotionFlags = []int{4, 5, 6, 7}
-var doublePawnMoveFlag = []int{8}
-var enPassantMoveFlag = []int{16}
-
 func BishopMasks(includeEdges bool) [64]uint64 {
 	var mapping [64]uint64
 	for i := 0; i < 64; i++ {
@@ -35,7 +35,7 @@ func BishopMasks(includeEdges bool) [64]uint64 {
 
 func BishopMagics() [64]uint64 {
 	var mapping [64]uint64
-	lines, _ := ReadLines("resources/move_tables/magics_bishop.txt")
+	lines := moveTableLines("magics_bishop.txt")
 	for _, line := range lines {
 		split := strings.Split(line, ";")
 		pos, _ := strconv.Atoi(split[0])
@@ -55,7 +55,7 @@ func BishopBits() [64]int {
 
 func BishopMoveTable() map[MagicKey]uint64 {
 	var mapping = make(map[MagicKey]uint64)
-	lines, _ := ReadLines("resources/move_tables/bishop.txt")
+	lines := moveTableLines("bishop.txt")
 	for _, line := range lines {
 		split := strings.Split(line, ";")
 		pos, _ := strconv.Atoi(split[0])
@@ -76,7 +76,7 @@ func RookMasks(includeEdges bool) [64]uint64 {
 
 func RookMagics() [64]uint64 {
 	var mapping [64]uint64
-	lines, _ := ReadLines("resources/move_tables/magics_rook.txt")
+	lines := moveTableLines("magics_rook.txt")
 	for _, line := range lines {
 		split := strings.Split(line, ";")
 		pos, _ := strconv.Atoi(split[0])
@@ -96,7 +96,7 @@ func RookBits() [64]int {
 
 func RookMoveTable() map[MagicKey]uint64 {
 	var mapping = make(map[MagicKey]uint64)
-	lines, _ := ReadLines("resources/move_tables/rook.txt")
+	lines := moveTableLines("rook.txt")
 	for _, line := range lines {
 		split := strings.Split(line, ";")
 		pos, _ := strconv.Atoi(split[0])
@@ -107,80 +107,60 @@ func RookMoveTable() map[MagicKey]uint64 {
 	return mapping
 }
 
-// Static moves
-func KingMovesMapping() [64][]Move {
-	return generateStaticMoves(kingOffsets, 0, 64)
-}
-
-func KnightMovesMapping() [64][]Move {
-	return generateStaticMoves(knightOffsets, 0, 64)
-}
-
-func WhitePawnStraightMovesMapping() [64][]Move {
-	nonPromotingMoves := generateStaticMoves(whitePawnStraightOffsets, 8, 48)
-	promotingMoves := generateStaticMovesWithFlags(whitePawnStraightOffsets, 48, 56, promotionFlags)
-	return mergeMoveMaps(nonPromotingMoves, promotingMoves, 8, 48)
-}
-
-func WhitePawnDoubleMovesMapping() [64][]Move {
-	return generateStaticMovesWithFlags(whitePawnDoubleOffsets, 8, 16, doublePawnMoveFlag)
-}
-
-func WhitePawnAttackMovesMapping() [64][]Move {
-	nonPromotingMoves := generateStaticMoves(whitePawnAttackOffsets, 8, 48)
-	promotingMoves := generateStaticMovesWithFlags(whitePawnAttackOffsets, 48, 56, promotionFlags)
-	return mergeMoveMaps(nonPromotingMoves, promotingMoves, 8, 56)
-}
-
-func WhiteEnPassantMovesMapping() [64][]Move {
-	return generateStaticMovesWithFlags(whitePawnAttackOffsets, 32, 40, enPassantMoveFlag)
-}
-
-func BlackPawnDoubleMovesMapping() [64][]Move {
-	return generateStaticMovesWithFlags(blackPawnDoubleOffsets, 48, 56, doublePawnMoveFlag)
-}
-
-func BlackPawnStraightMovesMapping() [64][]Move {
-	nonPromotingMoves := generateStaticMoves(blackPawnStraightOffsets, 16, 56)
-	promotingMoves := generateStaticMovesWithFlags(blackPawnStraightOffsets, 8, 16, promotionFlags)
-	return mergeMoveMaps(nonPromotingMoves, promotingMoves, 8, 56)
-}
-
-func BlackPawnAttackMovesMapping() [64][]Move {
-	nonPromotingMoves := generateStaticMoves(blackPawnAttackOffsets, 16, 56)
-	promotingMoves := generateStaticMovesWithFlags(blackPawnAttackOffsets, 8, 16, promotionFlags)
-	return mergeMoveMaps(nonPromotingMoves, promotingMoves, 8, 56)
-}
-
-func BlackEnPassantMovesMapping() [64][]Move {
-	return generateStaticMovesWithFlags(blackPawnAttackOffsets, 24, 32, enPassantMoveFlag)
-}
-
-func generateStaticMoves(offsets [][2]int, from int, to int) [64][]Move {
-	standardFlags := []int{0}
-	return generateStaticMovesWithFlags(offsets, from, to, standardFlags)
+func moveTableLines(name string) []string {
+	content, err := moveTables.ReadFile("resources/move_tables/" + name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return strings.Fields(string(content))
 }
 
-func generateStaticMovesWithFlags(offsets [][2]int, from int, to int, flags []int) [64][]Move {
-	var moves [64][]Move
-	var currentMoves []Move
+// MagicAttackTable flattens a move table into one slice per square, indexed by the magic key. Looking up a
+// slice is a lot faster than hashing a MagicKey, which matters in the move generator.
+func MagicAttackTable(moveTable map[MagicKey]uint64, bits [64]int) [64][]uint64 {
+	var table [64][]uint64
+	for i := 0; i < 64; i++ {
+		table[i] = make([]uint64, 1<<bits[i])
+	}
+	for key, attacks := range moveTable {
+		table[key.Square][key.Key] = attacks
+	}
+	return table
+}
+
+// BetweenAndLineMasks returns two mappings for every pair of squares on the same rank, file or diagonal:
+// the squares strictly between them, and the whole line through them from edge to edge. Other pairs map to 0.
+func BetweenAndLineMasks() ([64][64]uint64, [64][64]uint64) {
+	var between, line [64][64]uint64
+	ray := func(origin int, offset [2]int) []int {
+		var squares []int
+		rank, file := IndexToCartesian(origin)
+		for rank, file = rank+offset[0], file+offset[1]; rank >= 1 && rank <= 8 && file >= 1 && file <= 8; rank, file = rank+offset[0], file+offset[1] {
+			squares = append(squares, CartesianToIndex(rank, file))
+		}
+		return squares
+	}
 
-	for origin := from; origin < to; origin++ {
-		for _, destination := range generateDestinations(origin, offsets) {
-			for _, flag := range flags {
-				destinationBits := uint32(destination)
-				originBits := uint32(origin) << 6
-				flagBits := uint32(flag) << 12
+	for origin := 0; origin < 64; origin++ {
+		// The king offsets are the eight directions a queen can move in.
+		for _, offset := range kingOffsets {
+			fullLine := posToBitBoard(origin)
+			for _, square := range ray(origin, offset) {
+				fullLine |= posToBitBoard(square)
+			}
+			for _, square := range ray(origin, [2]int{-offset[0], -offset[1]}) {
+				fullLine |= posToBitBoard(square)
+			}
 
-				move := Move{destinationBits | originBits | flagBits}
-				currentMoves = append(currentMoves, move)
+			var squaresBetween uint64
+			for _, square := range ray(origin, offset) {
+				between[origin][square] = squaresBetween
+				line[origin][square] = fullLine
+				squaresBetween |= posToBitBoard(square)
 			}
 		}
-		moves[origin] = currentMoves
-		currentMoves = nil
 	}
-
-	return moves
+	return between, line
 }
 
 func generateDestinations(i int, offsets [][2]int) []int {
@@ -208,14 +188,6 @@ func PosToBitBoard() map[int]uint64 {
 	return mapping
 }
 
-func mergeMoveMaps(a [64][]Move, b [64][]Move, from int, to int) [64][]Move {
-	var merged [64][]Move
-	for i := from; i < to; i++ {
-		merged[i] = append(a[i], b[i]...)
-	}
-	return merged
-}
-
 // Attack masks
 func KnightMasks() [64]uint64 {
 	return generateMaskMapping(knightOffsets)
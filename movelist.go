@@ -0,0 +1,42 @@
+package main
+
+// Upper bound on the number of legal moves in any chess position. The largest known is 218.
+const maxMoves = 256
+
+// MoveList is a fixed-size list of moves, so generating moves doesn't allocate.
+type MoveList struct {
+	Moves [maxMoves]Move
+	Count int
+}
+
+func (ml *MoveList) Add(m Move) {
+	ml.Moves[ml.Count] = m
+	ml.Count++
+}
+
+// Slice returns the generated moves. It shares memory with the list.
+func (ml *MoveList) Slice() []Move {
+	return ml.Moves[:ml.Count]
+}
+
+func (ml *MoveList) Contains(m Move) bool {
+	for _, move := range ml.Moves[:ml.Count] {
+		if move == m {
+			return true
+		}
+	}
+	return false
+}
+
+type GenMode int
+
+const (
+	// All legal moves.
+	GenAll GenMode = iota
+	// Captures, en passant and promotions, i.e. the moves that change the material balance.
+	GenCaptures
+	// All other moves, including castling. Together with GenCaptures this is every legal move.
+	GenQuiet
+	// Moves that get the king out of check. Only meaningful when the side to move is in check.
+	GenEvasions
+)
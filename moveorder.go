@@ -0,0 +1,169 @@
+package main
+
+import "sync/atomic"
+
+// Move ordering scores. The bands never overlap, so the TT move is tried first, then captures that don't lose
+// material, then the killers, then the quiet moves in the order of the history table, and finally the
+// captures that lose material according to SEE.
+const (
+	ttMoveScore     = 1 << 30
+	captureScore    = 1 << 26
+	killerScore     = 1 << 24
+	historyMax      = 1 << 20
+	badCaptureScore = -(1 << 26)
+	numKillers      = 2
+)
+
+// moveOrderer holds the ordering heuristics of one search goroutine. Killers and history are learnt from
+// beta cutoffs, and are kept between iterations of the same search.
+type moveOrderer struct {
+	// Quiet moves that caused a beta cutoff at each ply. Sibling nodes often have the same refutation.
+	killers [maxPly][numKillers]Move
+	// How often a quiet move from one square to another caused a cutoff, weighted by depth, per color.
+	history [2][64][64]int32
+}
+
+// CutoffStats counts beta cutoffs. With good move ordering most cutoffs happen on the first move searched.
+type CutoffStats struct {
+	Cutoffs          uint64
+	FirstMoveCutoffs uint64
+}
+
+// FirstMoveCutoffRate is the share of cutoffs caused by the first move, between 0 and 1.
+func (c CutoffStats) FirstMoveCutoffRate() float64 {
+	if c.Cutoffs == 0 {
+		return 0
+	}
+	return float64(c.FirstMoveCutoffs) / float64(c.Cutoffs)
+}
+
+type cutoffCounter struct {
+	cutoffs          atomic.Uint64
+	firstMoveCutoffs atomic.Uint64
+}
+
+func (c *cutoffCounter) record(moveIndex int) {
+	c.cutoffs.Add(1)
+	if moveIndex == 0 {
+		c.firstMoveCutoffs.Add(1)
+	}
+}
+
+func (c *cutoffCounter) stats() CutoffStats {
+	return CutoffStats{Cutoffs: c.cutoffs.Load(), FirstMoveCutoffs: c.firstMoveCutoffs.Load()}
+}
+
+// movePicker hands out the moves of a list from the best to the worst score. Moves are picked by selection
+// instead of sorting up front, since a cutoff usually happens before the whole list has been looked at.
+type movePicker struct {
+	moves  MoveList
+	scores [maxMoves]int32
+	next   int
+}
+
+// init generates the moves of mode and scores them. The orderer may be nil, for example in the
+// quiescence search which only looks at captures.
+func (p *movePicker) init(b *BitBoard, mode GenMode, ttMove Move, orderer *moveOrderer, ply int8) {
+	p.next = 0
+	GenerateMoves(b, mode, &p.moves)
+	color := b.Turn()
+	for i, m := range p.moves.Slice() {
+		switch {
+		case m == ttMove:
+			p.scores[i] = ttMoveScore
+		case isTactical(b, &m) && isLosingCapture(b, m):
+			p.scores[i] = badCaptureScore + mvvLva(b, m)
+		case isTactical(b, &m):
+			p.scores[i] = captureScore + mvvLva(b, m)
+		case orderer == nil:
+			p.scores[i] = 0
+		case m == orderer.killers[ply][0]:
+			p.scores[i] = killerScore + 1
+		case m == orderer.killers[ply][1]:
+			p.scores[i] = killerScore
+		default:
+			p.scores[i] = orderer.history[color][m.Origin()][m.Destination()]
+		}
+	}
+}
+
+// remove takes m out of the moves that have not been returned yet.
+func (p *movePicker) remove(m Move) {
+	for i := p.next; i < p.moves.Count; i++ {
+		if p.moves.Moves[i] == m {
+			last := p.moves.Count - 1
+			p.moves.Moves[i], p.scores[i] = p.moves.Moves[last], p.scores[last]
+			p.moves.Count--
+			return
+		}
+	}
+}
+
+func (p *movePicker) count() int {
+	return p.moves.Count
+}
+
+// nextMove returns the best move not returned yet, and false when all moves have been returned.
+func (p *movePicker) nextMove() (Move, bool) {
+	if p.next >= p.moves.Count {
+		return Move{}, false
+	}
+	best := p.next
+	for i := p.next + 1; i < p.moves.Count; i++ {
+		if p.scores[i] > p.scores[best] {
+			best = i
+		}
+	}
+	p.moves.Moves[p.next], p.moves.Moves[best] = p.moves.Moves[best], p.moves.Moves[p.next]
+	p.scores[p.next], p.scores[best] = p.scores[best], p.scores[p.next]
+	p.next++
+	return p.moves.Moves[p.next-1], true
+}
+
+// mvvLva orders captures by the most valuable victim first, and among those by the least valuable attacker.
+// Promotions count the promoted piece as the victim.
+func mvvLva(b *BitBoard, m Move) int32 {
+	victim := b.PieceAt(m.Destination()).piece
+	if m.IsEnPassantMove() {
+		victim = Pawn
+	}
+	if promotion := m.Promotion(); promotion != Empty {
+		victim = max(victim, promotion)
+	}
+	attacker := b.PieceAt(m.Origin()).piece
+	return int32(8*(int(victim)+1) - int(attacker))
+}
+
+// update learns from a quiet move that caused a beta cutoff at the given ply and remaining depth. The quiet
+// moves that were searched before it and failed to cut off are penalized.
+func (o *moveOrderer) update(b *BitBoard, m Move, ply int8, depth int8, triedQuiets []Move) {
+	if o.killers[ply][0] != m {
+		o.killers[ply][1] = o.killers[ply][0]
+		o.killers[ply][0] = m
+	}
+
+	color := b.Turn()
+	bonus := int32(depth) * int32(depth)
+	o.history[color][m.Origin()][m.Destination()] += bonus
+	for _, tried := range triedQuiets {
+		if tried != m {
+			entry := &o.history[color][tried.Origin()][tried.Destination()]
+			*entry = max(*entry-bonus, -historyMax)
+		}
+	}
+	if o.history[color][m.Origin()][m.Destination()] >= historyMax {
+		o.ageHistory()
+	}
+}
+
+// ageHistory halves the history table, so recent cutoffs weigh more than old ones and the scores stay
+// below the killer band.
+func (o *moveOrderer) ageHistory() {
+	for color := range o.history {
+		for from := range o.history[color] {
+			for to := range o.history[color][from] {
+				o.history[color][from][to] /= 2
+			}
+		}
+	}
+}
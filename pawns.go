@@ -0,0 +1,245 @@
+package main
+
+import "sync/atomic"
+
+// Pawn structure scores in centipawns for the early and the late game. Tables are indexed by the rank of
+// the pawn as seen from its own side, so index 1 is the starting rank.
+const (
+	doubledPawnEarly  = -10
+	doubledPawnLate   = -25
+	isolatedPawnEarly = -10
+	isolatedPawnLate  = -15
+	backwardPawnEarly = -8
+	backwardPawnLate  = -12
+)
+
+var (
+	connectedPawn   = [8]int{0, 5, 7, 10, 18, 30, 50, 0}
+	passedPawnEarly = [8]int{0, 5, 10, 15, 30, 55, 90, 0}
+	passedPawnLate  = [8]int{0, 10, 15, 25, 50, 90, 140, 0}
+)
+
+const pawnTableEntries = 1 << 16
+
+var (
+	fileMasks         = FileMasks()
+	adjacentFileMasks = AdjacentFileMasks()
+	// Squares in front of a pawn on its own file, per color.
+	frontSpans = FrontSpans()
+	// Squares in front of a pawn on its own and the adjacent files. A pawn is passed if no enemy pawn is on them.
+	passedPawnMasks = PassedPawnMasks()
+	pawnHashTable   = newPawnTable(pawnTableEntries)
+)
+
+func FileMasks() [8]uint64 {
+	var masks [8]uint64
+	for file := range masks {
+		masks[file] = 0x0101010101010101 << file
+	}
+	return masks
+}
+
+func AdjacentFileMasks() [8]uint64 {
+	var masks [8]uint64
+	for file := range masks {
+		if file > 0 {
+			masks[file] |= 0x0101010101010101 << (file - 1)
+		}
+		if file < 7 {
+			masks[file] |= 0x0101010101010101 << (file + 1)
+		}
+	}
+	return masks
+}
+
+func FrontSpans() [2][64]uint64 {
+	var spans [2][64]uint64
+	for square := 0; square < 64; square++ {
+		for ahead := square + 8; ahead < 64; ahead += 8 {
+			spans[White][square] |= posToBitBoard(ahead)
+		}
+		for ahead := square - 8; ahead >= 0; ahead -= 8 {
+			spans[Black][square] |= posToBitBoard(ahead)
+		}
+	}
+	return spans
+}
+
+func PassedPawnMasks() [2][64]uint64 {
+	var masks [2][64]uint64
+	for color := range masks {
+		for square := 0; square < 64; square++ {
+			span := frontSpans[color][square]
+			masks[color][square] = span | (span<<1)&notAFile | (span>>1)&notHFile
+		}
+	}
+	return masks
+}
+
+// pawnTable caches the pawn structure, which only depends on the pawns and so changes far less often than
+// the position. Like the transposition table it is shared by all search goroutines without locks, and an
+// entry is only accepted if its check word matches the key XOR-ed with both data words of the same write.
+type pawnTable struct {
+	entries []pawnEntry
+	mask    uint64
+}
+
+type pawnEntry struct {
+	check uint64
+	// Bit  0-31: early game score, two's complement
+	// Bit 32-63: late game score, two's complement
+	score  uint64
+	passed uint64
+}
+
+func newPawnTable(numEntries int) *pawnTable {
+	return &pawnTable{entries: make([]pawnEntry, numEntries), mask: uint64(numEntries - 1)}
+}
+
+func (pt *pawnTable) probe(key uint64) (early int, late int, passed uint64, found bool) {
+	entry := &pt.entries[key&pt.mask]
+	score := atomic.LoadUint64(&entry.score)
+	passed = atomic.LoadUint64(&entry.passed)
+	if atomic.LoadUint64(&entry.check)^score^passed != key {
+		return 0, 0, 0, false
+	}
+	return int(int32(score)), int(int32(score >> 32)), passed, true
+}
+
+func (pt *pawnTable) store(key uint64, early int, late int, passed uint64) {
+	entry := &pt.entries[key&pt.mask]
+	score := uint64(uint32(int32(early))) | uint64(uint32(int32(late)))<<32
+	atomic.StoreUint64(&entry.score, score)
+	atomic.StoreUint64(&entry.passed, passed)
+	atomic.StoreUint64(&entry.check, key^score^passed)
+}
+
+// pawnKey hashes the pawns of both colors. Unlike the Zobrist key it ignores all other pieces.
+func pawnKey(board *BitBoard) uint64 {
+	return MurmurHash(board.PawnBB&board.WhiteBB ^ MurmurHash(board.PawnBB&board.BlackBB))
+}
+
+// pawns returns the pawn score in centipawns for the early and the late game, from white's point of view.
+// The structure itself comes from the pawn hash table when possible. How far passed pawns can advance
+// depends on the other pieces, so that part is evaluated every time.
+func pawns(board *BitBoard) (int, int) {
+	key := pawnKey(board)
+	early, late, passed, found := pawnHashTable.probe(key)
+	if !found {
+		whitePawns, blackPawns := board.PawnBB&board.WhiteBB, board.PawnBB&board.BlackBB
+		whiteEarly, whiteLate, whitePassed := pawnStructure(White, whitePawns, blackPawns)
+		blackEarly, blackLate, blackPassed := pawnStructure(Black, blackPawns, whitePawns)
+		early, late, passed = whiteEarly-blackEarly, whiteLate-blackLate, whitePassed|blackPassed
+		pawnHashTable.store(key, early, late, passed)
+	}
+
+	whiteEarly, whiteLate := passedPawns(board, White, passed&board.WhiteBB)
+	blackEarly, blackLate := passedPawns(board, Black, passed&board.BlackBB)
+	return early + whiteEarly - blackEarly, late + whiteLate - blackLate
+}
+
+// pawnStructure scores the doubled, isolated, backward, connected and passed pawns of one color, and
+// returns the passed pawns.
+func pawnStructure(color Color, ours uint64, theirs uint64) (early int, late int, passed uint64) {
+	ourAttacks := pawnAttacks(color, ours)
+	theirAttacks := pawnAttacks(color.Opposite(), theirs)
+
+	for remaining := ours; remaining != 0; {
+		square, rest := PopFistBit(remaining)
+		remaining = rest
+		bit := posToBitBoard(square)
+		file, rank := square%8, relativeRank(color, square)
+
+		// Only the rear pawn of a doubled pair is penalized, and only the front one can be passed.
+		doubled := ours&frontSpans[color][square] != 0
+		isolated := ours&adjacentFileMasks[file] == 0
+		supported := ourAttacks&bit != 0
+		phalanx := ours&((bit<<1)&notAFile|(bit>>1)&notHFile) != 0
+		// A backward pawn has no pawns beside or behind it on the adjacent files to support its advance, and
+		// its stop square is controlled by an enemy pawn.
+		backward := !isolated && !supported &&
+			ours&adjacentFileMasks[file]&^passedPawnMasks[color][square] == 0 &&
+			theirAttacks&stopSquare(color, square) != 0
+
+		if doubled {
+			early += doubledPawnEarly
+			late += doubledPawnLate
+		}
+		if isolated {
+			early += isolatedPawnEarly
+			late += isolatedPawnLate
+		} else if backward {
+			early += backwardPawnEarly
+			late += backwardPawnLate
+		}
+		if supported || phalanx {
+			early += connectedPawn[rank]
+			late += connectedPawn[rank]
+		}
+		if !doubled && theirs&passedPawnMasks[color][square] == 0 {
+			early += passedPawnEarly[rank]
+			late += passedPawnLate[rank]
+			passed |= bit
+		}
+	}
+	return early, late, passed
+}
+
+// passedPawns adds to the passed pawns of one color for a free path to the promotion square, and in the late
+// game for the enemy king being far from the square in front of the pawn and the own king being close to
+// it. Both grow with how far the pawn has advanced.
+func passedPawns(board *BitBoard, color Color, passed uint64) (early int, late int) {
+	occupied := board.WhiteBB | board.BlackBB
+	ownKing := LSB(board.KingBB & colorBoard(board, color))
+	theirKing := LSB(board.KingBB & colorBoard(board, color.Opposite()))
+
+	for passed != 0 {
+		square, rest := PopFistBit(passed)
+		passed = rest
+		weight := relativeRank(color, square) - 2
+		if weight <= 0 {
+			continue
+		}
+		stop := LSB(stopSquare(color, square))
+		late += weight * (5*squareDistance(theirKing, stop) - 2*squareDistance(ownKing, stop))
+		if occupied&frontSpans[color][square] == 0 {
+			early += 5 * weight
+			late += 10 * weight
+		}
+	}
+	return early, late
+}
+
+func pawnAttacks(color Color, pawns uint64) uint64 {
+	if color == White {
+		return (pawns<<7)&notHFile | (pawns<<9)&notAFile
+	}
+	return (pawns>>9)&notHFile | (pawns>>7)&notAFile
+}
+
+func stopSquare(color Color, square int) uint64 {
+	if color == White {
+		return posToBitBoard(square) << 8
+	}
+	return posToBitBoard(square) >> 8
+}
+
+// relativeRank is the rank of a square as seen from color's side of the board, from 0 to 7.
+func relativeRank(color Color, square int) int {
+	if color == White {
+		return square / 8
+	}
+	return 7 - square/8
+}
+
+// squareDistance is the number of king moves between two squares on an empty board.
+func squareDistance(a int, b int) int {
+	return max(abs(a%8-b%8), abs(a/8-b/8))
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -1,195 +1,722 @@
 package main
 
 import (
-	"math/rand"
+	"context"
+	"runtime"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type EvaluatedBoard struct {
+	// The best move, and the board after it has been played.
+	move  Move
 	board BitBoard
-	eval  float64
+	// Evaluation in pawns from white's perspective.
+	eval float64
+	// Mate in this many moves, positive when white mates and negative when black mates. Zero without a mate.
+	mate int
+	// The principal variation, starting with move.
+	pv []Move
+	// Statistics for the iteration that produced the result.
+	info SearchInfo
+	// The lines of a MultiPV search, best first. The first one is info.
+	lines []SearchInfo
 }
 
-const maxDepth = 5
-const deterministic = true
-const randomRange = 0
-const maxRoutines = 16
+// SearchInfo describes a completed iteration of iterative deepening.
+type SearchInfo struct {
+	Depth    int
+	SelDepth int
+	// Score in centipawns from the perspective of the side to move.
+	Score int
+	// Mate in this many moves for the side to move, negative when it gets mated. Zero without a mate.
+	Mate    int
+	Nodes   uint64
+	NPS     uint64
+	Elapsed time.Duration
+	// Permille of the transposition table in use.
+	HashFull int
+	PV       []Move
+	Cutoffs  CutoffStats
+	// Rank of the line in a MultiPV search, starting at 1.
+	MultiPV int
+}
+
+// SearchOptions configures a search beyond its limits.
+type SearchOptions struct {
+	// Called after every completed iteration, from the goroutine running the search.
+	OnInfo func(SearchInfo)
+
+	// Keys of the game positions before the searched one, oldest first, to detect repetitions.
+	History []uint64
+	// Centipawns the engine is willing to give up to avoid a draw. A negative contempt makes it seek draws.
+	Contempt int
+	// Number of goroutines searching in parallel. Zero uses one per CPU.
+	Threads int
+	// Receives the time limits when the opponent plays the expected move of a ponder search.
+	PonderHit <-chan SearchLimits
+	// Number of best moves to search, each with its own score and principal variation. OnInfo is called for
+	// every line. Zero or one searches only the best move.
+	MultiPV int
+	// Weakens the engine to the skill level. Nil plays at full strength.
+	Skill *Skill
+
+	// The selective search techniques are all enabled by default. They can be turned off one by one,
+	// to measure what each of them is worth in engine matches.
+	DisableNullMove        bool
+	DisableLMR             bool
+	DisableFutility        bool
+	DisableCheckExtensions bool
+}
+
+// PVString formats the principal variation as space separated UCI moves.
+func (info SearchInfo) PVString() string {
+	moves := make([]string, len(info.PV))
+	for i, move := range info.PV {
+		moves[i] = move.ToUCI()
+	}
+	return strings.Join(moves, " ")
+}
+
+const maxDepth = 64
+const maxPly = 100
+
+// Search scores are in centipawns from the perspective of the side to move, and never outside the
+// (-infinity, infinity) window. Being mated n plies from the root scores -(mateScore - n), so a faster mate
+// scores higher, and a slower one when being mated.
+const (
+	infinity  = 32000
+	mateScore = 30000
+	// Scores beyond this are mate scores, which must not be used as pruning bounds.
+	mateThreshold = mateScore - maxPly
+)
+
+// Once the same mate score has come out of this many iterations in a row, and the depth is at least twice the
+// moves to mate, deeper iterations won't change it and iterative deepening stops.
+const mateStableIterations = 2
+
+// Positional slack in centipawns used by delta pruning in the quiescence search.
+const deltaMargin = 200
+
+// Selective search parameters. Depths are the remaining depth in plies, margins are in centipawns.
+const (
+	nullMoveMinDepth = 3
+	// Below this depth a null move cutoff is trusted without a verification search.
+	nullMoveVerifyDepth = 8
+	futilityDepth       = 3
+	futilityMargin      = 100
+	// Reverse futility prunes nodes whose static eval beats beta by this margin per ply.
+	reverseFutilityMargin = 90
+	lmrMinDepth           = 3
+	// Quiet moves are only reduced after this many moves have been searched at full depth.
+	lmrMinMoves = 3
+)
+
+// Aspiration windows. From aspirationMinDepth on, the root is searched with a narrow window around the score
+// of the previous iteration. When the score falls outside, the window is widened on that side and the
+// iteration is repeated.
+const (
+	aspirationMinDepth = 4
+	aspirationWindow   = 25
+)
+
+var transpositionTable = newTranspositionTable(defaultHashSizeMB)
 
-var transpositionTable = newTranspositionTable()
+// SearchLimits bounds a search. The zero value searches to maxDepth without any time limit.
+type SearchLimits struct {
+	// Fixed time to spend on the move.
+	MoveTime time.Duration
+	// Clock of the side to move, used when Clock.Remaining is set and MoveTime is not.
+	Clock TimeControl
+	// Maximum depth in plies. Capped at maxDepth.
+	Depth int
+	// Stop after searching roughly this many nodes.
+	Nodes uint64
+	// Only look for a mate in this many moves, and stop as soon as one is found.
+	Mate int
+	// Search until the context is cancelled. The depth cap still applies.
+	Infinite bool
+	// Search the position after the expected reply of the opponent, while the opponent is thinking. There is
+	// no time limit until SearchOptions.PonderHit delivers the limits to use from then on.
+	Ponder bool
+}
+
+// searchContext is shared by all workers of a single search. It counts nodes and tells them when to abort.
+type searchContext struct {
+	ctx       context.Context
+	options   SearchOptions
+	rootColor Color
+	start     time.Time
+	nodes     atomic.Uint64
+	nodeLimit uint64
+	selDepth  atomic.Int32
+	cutoffs   cutoffCounter
+	stopped   atomic.Bool
+	// The first iteration is never aborted, so that there is always a move to return.
+	armed atomic.Bool
+}
+
+// shouldAbort is called once for every node. The context is only checked every 1024 nodes to keep it cheap.
+func (s *searchContext) shouldAbort(ply int8) bool {
+	n := s.nodes.Add(1)
+	if int32(ply) > s.selDepth.Load() {
+		s.selDepth.Store(int32(ply))
+	}
+	if !s.armed.Load() {
+		return false
+	}
+	if s.stopped.Load() {
+		return true
+	}
+	if (s.nodeLimit > 0 && n >= s.nodeLimit) || (n&1023 == 0 && s.ctx.Err() != nil) {
+		s.stopped.Store(true)
+		return true
+	}
+	return false
+}
 
-func BestMoveWithoutTimeLimit(board *BitBoard) EvaluatedBoard {
-	return BestMove(board, 5*time.Second)
+// pvLine is one row of a triangular PV table: the moves along the best line found below a node.
+type pvLine struct {
+	moves  [maxPly]Move
+	length int
 }
 
-func BestMove(board *BitBoard, timeLimit time.Duration) EvaluatedBoard {
-	var bestMove BitBoard
-	var bestEval float64
+func (l *pvLine) update(move Move, childLine *pvLine) {
+	l.moves[0] = move
+	l.length = copy(l.moves[1:], childLine.moves[:childLine.length]) + 1
+}
+
+// searchWorker is the state of a single search goroutine.
+type searchWorker struct {
+	// The worker's own copy of the root position, on which it makes and unmakes moves.
+	board   BitBoard
+	undo    *UndoStack
+	orderer moveOrderer
+	// Set during the verification search of a null move cutoff.
+	noNullMove bool
+	// Root moves that are not searched, because they were found as earlier lines of a MultiPV search.
+	excludedRootMoves []Move
+}
+
+func newSearchWorker(board *BitBoard) *searchWorker {
+	return &searchWorker{board: *board, undo: NewUndoStack()}
+}
+
+// Analyze searches the numLines best moves, each with its own score and principal variation, and returns
+// them best first.
+func Analyze(ctx context.Context, board *BitBoard, limits SearchLimits, options SearchOptions, numLines int) []SearchInfo {
+	options.MultiPV = numLines
+	return Search(ctx, board, limits, options).lines
+}
+
+// Search runs iterative deepening until the limits are reached or ctx is cancelled. It returns the best move
+// from the last completed iteration.
+//
+// The search is parallelized with Lazy SMP: helper goroutines run their own iterative deepening on the same
+// position, and only share the transposition table with the main one. Half of the helpers search one ply
+// deeper than the main goroutine, so they don't all follow the same path through the tree. The entries they
+// leave in the table speed up and improve the search of the main goroutine, which alone decides the move.
+func Search(ctx context.Context, board *BitBoard, limits SearchLimits, options SearchOptions) EvaluatedBoard {
+	var bestMove Move
+	var bestScore int
+	var bestInfo SearchInfo
+
+	transpositionTable.NewSearch()
 
-	transpositionTable.Clear()
+	var rootMoves MoveList
+	GenerateMoves(board, GenAll, &rootMoves)
+	if rootMoves.Count == 0 {
+		return EvaluatedBoard{}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The time manager is read by the iterative deepening loop, and may be created by the ponder hit goroutine.
+	var tm atomic.Pointer[TimeManager]
+	startClock := func(limits SearchLimits) {
+		if limits.Infinite {
+			return
+		}
+		if limits.MoveTime > 0 {
+			tm.Store(NewFixedTimeManager(limits.MoveTime))
+		} else if limits.Clock.Remaining > 0 {
+			tm.Store(NewTimeManager(limits.Clock))
+		} else {
+			return
+		}
+		deadline := time.AfterFunc(time.Until(tm.Load().Deadline()), cancel)
+		context.AfterFunc(ctx, func() { deadline.Stop() })
+	}
+	// While pondering there is no time limit. The clock starts on a ponder hit, which turns pondering into a
+	// normal search. A ponder miss cancels ctx, and the result is thrown away.
+	if limits.Ponder {
+		go func() {
+			select {
+			case hitLimits := <-options.PonderHit:
+				startClock(hitLimits)
+			case <-ctx.Done():
+			}
+		}()
+	} else {
+		startClock(limits)
+	}
 
-	legalMoves, numMoves := GenerateLegalStates(board)
-	if numMoves == 0 {
-		return EvaluatedBoard{bestMove, bestEval}
+	// A weakened search looks at extra lines to choose from, but only reports the lines that were asked for,
+	// so the candidates it didn't play stay hidden.
+	reportedLines := max(options.MultiPV, 1)
+	weakened := options.Skill != nil && options.Skill.limitsStrength()
+	if weakened {
+		limits, options = options.Skill.limit(limits, options)
 	}
 
-	isWhite := board.Turn() == White
-	isWhiteTurn := !isWhite // The child boards have the opposite turn
-	
-	
-	// Initialize move evaluations
-	moveEvals := make([]EvaluatedBoard, numMoves)
-	for i := range numMoves {
-		moveEvals[i] = EvaluatedBoard{legalMoves[i], 0.0}
+	depthLimit := int8(maxDepth)
+	if limits.Depth > 0 {
+		depthLimit = int8(min(limits.Depth, maxDepth))
 	}
-	
-	// Create persistent worker pool - workers live for all iterations
-	workQueue := make(chan EvaluatedBoard, numMoves)
-	resultQueue := make(chan EvaluatedBoard, numMoves)
-	depthQueue := make(chan int8, numMoves)
-	
-	var workerWg sync.WaitGroup
-	workerWg.Add(maxRoutines)
-	for range maxRoutines {
+	if limits.Mate > 0 {
+		depthLimit = int8(min(2*limits.Mate-1, maxDepth))
+	}
+
+	threads := options.Threads
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+
+	s := &searchContext{ctx: ctx, options: options, rootColor: board.Turn(), start: time.Now(), nodeLimit: limits.Nodes}
+
+	var helpers sync.WaitGroup
+	helpers.Add(threads - 1)
+	for id := 1; id < threads; id++ {
 		go func() {
-			defer workerWg.Done()
-			for board := range workQueue {
-				depth := <-depthQueue
-				eval := minimax(&board.board, 1, isWhiteTurn, -1000.0, 1000.0, depth)
-				resultQueue <- EvaluatedBoard{
-					board: board.board,
-					eval: eval * randomFactor(),
-				}
+			defer helpers.Done()
+			w := newSearchWorker(board)
+			var line pvLine
+			score := 0
+			for depth := 1 + int8(id%2); depth <= depthLimit && !s.stopped.Load(); depth++ {
+				score = w.searchRoot(s, depth, score, &line)
 			}
 		}()
 	}
-	
-	start := time.Now()
-	currentMaxDepth := int8(1)
-
-	for time.Since(start) < timeLimit && currentMaxDepth <= maxDepth {
-		var evals = make([]EvaluatedBoard, numMoves)
-		
-		// Send all work items to queue
-		for i := range numMoves {
-			workQueue <- moveEvals[i]
-			depthQueue <- currentMaxDepth
-		}
-		
-		// Collect all results and match by board hash
-		results := make(map[uint64]float64)
-		for range numMoves {
-			result := <-resultQueue
-			results[result.board.Hash()] = result.eval
-		}
-		
-		// Map results back to evals array
-		for i := range numMoves {
-			boardHash := moveEvals[i].board.Hash()
-			evals[i] = EvaluatedBoard{
-				board: moveEvals[i].board,
-				eval: results[boardHash],
+
+	numLines := min(max(options.MultiPV, 1), rootMoves.Count)
+	var lines []SearchInfo
+
+	w := newSearchWorker(board)
+	var line pvLine
+	mateStable := 0
+	for depth := int8(1); depth <= depthLimit && (depth == 1 || tm.Load() == nil || !tm.Load().ShouldStop()); depth++ {
+		// The root is searched once for every line. Each search skips the first moves of the lines before it.
+		iterationLines := make([]SearchInfo, 0, numLines)
+		w.excludedRootMoves = w.excludedRootMoves[:0]
+		for i := range numLines {
+			previousScore := 0
+			if i < len(lines) {
+				previousScore = lines[i].Score
 			}
+			score := w.searchRoot(s, depth, previousScore, &line)
+			if s.stopped.Load() {
+				break
+			}
+			w.excludedRootMoves = append(w.excludedRootMoves, line.moves[0])
+			iterationLines = append(iterationLines, SearchInfo{
+				Score: score,
+				Mate:  mateIn(score),
+				PV:    append([]Move(nil), line.moves[:line.length]...),
+			})
+		}
+		// An aborted iteration is incomplete, so keep the result of the previous one.
+		if s.stopped.Load() {
+			break
 		}
-		
-		// Update move evaluations and find best move
-		moveEvals = evals
-		bestEval = -1000.0
-		if !isWhite {
-			bestEval = 1000.0
-		}
-		
-		for _, evaledBoard := range evals {
-			isBetter := (isWhite && evaledBoard.eval > bestEval) || (!isWhite && evaledBoard.eval < bestEval)
-			if isBetter {
-				bestEval = evaledBoard.eval
-				bestMove = evaledBoard.board
+
+		// A later line may still score higher than an earlier one, since it is searched with a different window.
+		slices.SortStableFunc(iterationLines, func(a, b SearchInfo) int {
+			return b.Score - a.Score
+		})
+		elapsed := time.Since(s.start)
+		nodes := s.nodes.Load()
+		for i := range iterationLines {
+			info := &iterationLines[i]
+			info.Depth = int(depth)
+			info.SelDepth = int(s.selDepth.Load())
+			info.Nodes = nodes
+			info.NPS = uint64(float64(nodes) / max(elapsed.Seconds(), 0.001))
+			info.Elapsed = elapsed
+			info.HashFull = transpositionTable.HashFull()
+			info.Cutoffs = s.cutoffs.stats()
+			info.MultiPV = i + 1
+			if options.OnInfo != nil && i < reportedLines {
+				options.OnInfo(*info)
 			}
 		}
-		
-		currentMaxDepth++
+		lines = iterationLines
+		if lines[0].Mate != 0 && lines[0].Score == bestScore {
+			mateStable++
+		} else {
+			mateStable = 0
+		}
+		bestInfo = lines[0]
+		bestScore = bestInfo.Score
+		bestMove = bestInfo.PV[0]
+
+		if t := tm.Load(); t != nil {
+			t.Update(bestMove, bestScore)
+		}
+		s.armed.Store(true)
+
+		if limits.Mate > 0 && bestScore >= mateThreshold && mateIn(bestScore) <= limits.Mate {
+			break
+		}
+		if mateStable >= mateStableIterations && int(depth) >= 2*abs(bestInfo.Mate) {
+			break
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	// The main goroutine is done, so the helpers can stop wherever they are.
+	s.armed.Store(true)
+	s.stopped.Store(true)
+	helpers.Wait()
+
+	if weakened && len(lines) > 0 {
+		bestInfo = options.Skill.pickLine(lines)
+		bestScore = bestInfo.Score
+		bestMove = bestInfo.PV[0]
+	}
+
+	next := *board
+	next.MakeMove(bestMove, NewUndoStack())
+	mate := bestInfo.Mate
+	if board.Turn() == Black {
+		mate = -mate
+	}
+	return EvaluatedBoard{move: bestMove, board: next, eval: whitePawns(bestScore, board.Turn()), mate: mate, pv: bestInfo.PV, info: bestInfo, lines: lines}
+}
+
+// searchRoot runs one iteration of iterative deepening to the given depth, and collects the principal
+// variation in line. From aspirationMinDepth on, it starts with a narrow window around the score of the
+// previous iteration.
+func (w *searchWorker) searchRoot(s *searchContext, depth int8, previousScore int, line *pvLine) int {
+	alpha, beta := -infinity, infinity
+	delta := aspirationWindow
+	if depth >= aspirationMinDepth && previousScore > -mateThreshold && previousScore < mateThreshold {
+		alpha, beta = previousScore-delta, previousScore+delta
+	}
+
+	for {
+		score := negamax(s, w, &w.board, 0, depth, alpha, beta, line)
+		switch {
+		case s.stopped.Load():
+			return score
+		case score <= alpha:
+			alpha = max(score-delta, -infinity)
+		case score >= beta:
+			beta = min(score+delta, infinity)
+		default:
+			return score
+		}
+		delta *= 2
+	}
+}
+
+// mateIn converts a mate score to the number of moves until mate, negative when the side to move gets mated.
+// It returns zero for scores that are not mate scores.
+func mateIn(score int) int {
+	switch {
+	case score >= mateThreshold:
+		return (mateScore - score + 1) / 2
+	case score <= -mateThreshold:
+		return -(mateScore + score) / 2
 	}
-	
-	// Cleanup: close work queue and wait for workers to finish
-	close(workQueue)
-	close(depthQueue)
-	workerWg.Wait()
-	close(resultQueue)
+	return 0
+}
 
-	return EvaluatedBoard{bestMove, bestEval}
+// whitePawns converts a search score to pawns from white's perspective.
+func whitePawns(score int, turn Color) float64 {
+	if turn == Black {
+		score = -score
+	}
+	return float64(score) / 100
 }
 
-func minimax(board *BitBoard, depth int8, isWhite bool, alpha float64, beta float64, currentMaxDepth int8) float64 {
-	if depth >= currentMaxDepth {
-		eval := Eval(board)
-		return eval
+// negamax searches board, which is ply plies from the root, depth plies deep. It returns the score for the side
+// to move and collects the principal variation in line. The search is fail-soft, so the score may be outside
+// the window: at most alpha is an upper bound and at least beta is a lower bound. Moves are made and unmade on
+// board, so it is unchanged when negamax returns.
+func negamax(s *searchContext, w *searchWorker, board *BitBoard, ply int8, depth int8, alpha int, beta int, line *pvLine) int {
+	line.length = 0
+	if s.shouldAbort(ply) {
+		return 0
+	}
+	// The root always has to be searched, so that there is a move to play.
+	root := ply == 0
+	if !root && (isInsufficientMaterial(board) || isFiftyMoveDraw(board) || isRepetition(board, w.undo, s.options.History)) {
+		return s.drawScore(board)
+	}
+	if ply >= maxPly-1 {
+		return evaluate(board)
 	}
 
-	ttResult := transpositionTable.getUpperAndLower(board, depth)
-	if ttResult.upper < alpha {
-		return ttResult.upper
+	// Positions in check are searched one ply deeper, so a check at the horizon is always resolved.
+	inCheck := board.InCheck()
+	if inCheck && !s.options.DisableCheckExtensions {
+		depth++
 	}
-	if ttResult.lower >= beta {
-		return ttResult.lower
+	if depth <= 0 {
+		return quiescence(s, w, board, ply, alpha, beta)
 	}
 
-	childrenArray, numChildren := GenerateLegalStates(board)
-	if numChildren == 0 {
-		kingPos, _ := PopFistBit(board.KingBB)
-		isChecked := isChecked(board, kingPos, board.Turn())
-		eval := 0.0
-		if isChecked && isWhite {
-			eval = 1000.0
-		} else if isChecked && !isWhite {
-			eval = -1000.0
-		} else {
-			eval = 0.0
+	// Mate distance pruning: no line from here can beat mating right now, or be worse than being mated right
+	// now. If the window is outside these bounds, a shorter mate has already been found elsewhere.
+	alpha = max(alpha, -mateScore+int(ply))
+	beta = min(beta, mateScore-int(ply)-1)
+	if alpha >= beta {
+		return alpha
+	}
+
+	// Scores are stored with the depth remaining below the node, so they can be reused at any ply.
+	// The best move is worth trying first even if the entry is too shallow to be trusted. Nodes searched
+	// with an open window don't return early, so the principal variation doesn't get cut short.
+	key := board.Hash()
+	var ttMove Move
+	if entry, found := transpositionTable.Probe(key); found {
+		ttMove = entry.Move
+		if entry.Depth >= depth && beta-alpha == 1 {
+			score := scoreFromTT(entry.Score, ply)
+			switch {
+			case entry.Bound == BoundExact,
+				entry.Bound == BoundLower && score >= beta,
+				entry.Bound == BoundUpper && score <= alpha:
+				return score
+			}
 		}
-		return eval
 	}
+	alphaOrig := alpha
 
-	children := childrenArray[:numChildren]
-	
-	var bestEval float64
-	
-	if isWhite {
-		bestEval = -1000.0
-		for _, child := range children {
-			currentEval := minimax(&child, depth+1, false, alpha, beta, currentMaxDepth)
-			if currentEval > bestEval {
-				bestEval = currentEval
+	// Forward pruning needs a static eval, which is meaningless in check. The root is never pruned.
+	var staticEval int
+	if !inCheck && !root {
+		staticEval = evaluate(board)
+
+		// Reverse futility pruning: near the leaves, a static eval far above beta is unlikely to drop below it.
+		if !s.options.DisableFutility && depth <= futilityDepth && beta < mateThreshold &&
+			staticEval-reverseFutilityMargin*int(depth) >= beta {
+			return staticEval
+		}
+
+		// Null move pruning: if passing the turn still fails high, a real move will most likely do too.
+		// Zugzwang is the exception, so it's not tried without pieces, and deep cutoffs are verified.
+		if !s.options.DisableNullMove && !w.noNullMove && depth >= nullMoveMinDepth &&
+			staticEval >= beta && beta < mateThreshold && hasNonPawnMaterial(board) && !w.undo.lastMoveWasNull(board) {
+			reduction := int8(2)
+			if depth > 6 {
+				reduction = 3
 			}
-			if bestEval > alpha {
-				alpha = bestEval
+			var nullLine pvLine
+			board.MakeNullMove(w.undo)
+			nullScore := -negamax(s, w, board, ply+1, depth-1-reduction, -beta, -beta+1, &nullLine)
+			board.UnmakeMove(w.undo)
+
+			if nullScore >= beta {
+				if depth < nullMoveVerifyDepth {
+					return beta
+				}
+				w.noNullMove = true
+				verified := negamax(s, w, board, ply, depth-reduction, beta-1, beta, line)
+				w.noNullMove = false
+				if verified >= beta {
+					return beta
+				}
 			}
-			if beta <= alpha {
-				transpositionTable.setLower(board, bestEval, depth)
-				break
+		}
+	}
+
+	var picker movePicker
+	picker.init(board, GenAll, ttMove, &w.orderer, ply)
+	if root {
+		for _, excluded := range w.excludedRootMoves {
+			picker.remove(excluded)
+		}
+	}
+	if picker.count() == 0 {
+		return terminalScore(board, ply)
+	}
+
+	bestScore := -infinity
+	var bestMove Move
+	var childLine pvLine
+	// Quiet moves that didn't cause a cutoff, to be penalized in the history table when a later one does.
+	var triedQuiets MoveList
+
+	for i := 0; ; i++ {
+		move, ok := picker.nextMove()
+		if !ok {
+			break
+		}
+		quiet := !isTactical(board, &move)
+		killer := move == w.orderer.killers[ply][0] || move == w.orderer.killers[ply][1]
+
+		board.MakeMove(move, w.undo)
+		givesCheck := board.InCheck()
+
+		// Futility pruning: near the leaves, quiet moves can't raise a static eval far below alpha to alpha.
+		futilityValue := staticEval + futilityMargin*int(depth)
+		if !s.options.DisableFutility && !root && i > 0 && quiet && !inCheck && !givesCheck &&
+			depth <= futilityDepth && alpha > -mateThreshold && futilityValue <= alpha {
+			board.UnmakeMove(w.undo)
+			bestScore = max(bestScore, futilityValue)
+			continue
+		}
+
+		// Late move reductions: with good move ordering, quiet moves late in the list rarely turn out best.
+		// They are searched less deep first, and only searched again at full depth if they beat alpha.
+		var reduction int8
+		if !s.options.DisableLMR && i >= lmrMinMoves && quiet && !killer && !inCheck && !givesCheck &&
+			depth >= lmrMinDepth {
+			reduction = 1
+			if i >= 2*lmrMinMoves && depth >= 2*lmrMinDepth {
+				reduction = 2
 			}
 		}
-	} else {
-		bestEval = 1000.0
-		for _, child := range children {
-			currentEval := minimax(&child, depth+1, true, alpha, beta, currentMaxDepth)
-			if currentEval < bestEval {
-				bestEval = currentEval
+
+		// Principal variation search: the first move is expected to be best. The others are only searched
+		// with a null window to prove that they are not better, and again with the full window if they are.
+		var score int
+		if i == 0 {
+			score = -negamax(s, w, board, ply+1, depth-1, -beta, -alpha, &childLine)
+		} else {
+			score = -negamax(s, w, board, ply+1, depth-1-reduction, -alpha-1, -alpha, &childLine)
+			if score > alpha && reduction > 0 {
+				score = -negamax(s, w, board, ply+1, depth-1, -alpha-1, -alpha, &childLine)
 			}
-			if bestEval < beta {
-				beta = bestEval
+			if score > alpha && score < beta {
+				score = -negamax(s, w, board, ply+1, depth-1, -beta, -alpha, &childLine)
 			}
-			if beta <= alpha {
-				transpositionTable.setUpper(board, bestEval, depth)
-				break
+		}
+		board.UnmakeMove(w.undo)
+
+		if score > bestScore || line.length == 0 {
+			bestScore = score
+			bestMove = move
+			line.update(move, &childLine)
+		}
+		alpha = max(alpha, bestScore)
+		if alpha >= beta {
+			s.cutoffs.record(i)
+			if quiet {
+				w.orderer.update(board, move, ply, depth, triedQuiets.Slice())
 			}
+			break
 		}
+		if quiet {
+			triedQuiets.Add(move)
+		}
+	}
+
+	// The children of an aborted search return garbage, which must not end up in the table. Neither must the
+	// root score of a search that skipped moves.
+	if s.stopped.Load() || (root && len(w.excludedRootMoves) > 0) {
+		return bestScore
 	}
-	return bestEval
+	bound := BoundExact
+	if bestScore <= alphaOrig {
+		bound = BoundUpper
+	} else if bestScore >= beta {
+		bound = BoundLower
+	}
+	transpositionTable.Store(key, scoreToTT(bestScore, ply), depth, bound, bestMove)
+	return bestScore
+}
+
+// drawScore scores a draw for the side to move on board. With contempt, the engine rates a draw below equal
+// for itself, and above equal for the opponent.
+func (s *searchContext) drawScore(board *BitBoard) int {
+	if board.Turn() == s.rootColor {
+		return -s.options.Contempt
+	}
+	return s.options.Contempt
+}
+
+// hasNonPawnMaterial reports whether the side to move has a piece other than pawns and the king.
+// Without one, zugzwang is common and null move pruning is unsafe.
+func hasNonPawnMaterial(board *BitBoard) bool {
+	return (board.KnightBB|board.BishopBB|board.RookBB|board.QueenBB)&board.TurnBoard() != 0
 }
 
-func randomFactor() float64 {
-	if deterministic {
-		return 1.0
+// quiescence extends the search at the leaves with captures and promotions until the position is quiet,
+// so the static evaluation is never taken in the middle of an exchange.
+func quiescence(s *searchContext, w *searchWorker, board *BitBoard, ply int8, alpha int, beta int) int {
+	if s.shouldAbort(ply) {
+		return 0
+	}
+	if isInsufficientMaterial(board) {
+		return s.drawScore(board)
+	}
+	if ply >= maxPly-1 {
+		return evaluate(board)
+	}
+
+	var picker movePicker
+	var standPat int
+
+	inCheck := board.InCheck()
+	if inCheck {
+		// When in check, standing pat is not an option and every evasion has to be considered.
+		picker.init(board, GenEvasions, Move{}, nil, ply)
+		if picker.count() == 0 {
+			return terminalScore(board, ply)
+		}
+		standPat = -infinity
 	} else {
-		return (1.0 - randomRange) + rand.Float64()*randomRange
+		standPat = evaluate(board)
+		if standPat >= beta {
+			return standPat
+		}
+		alpha = max(alpha, standPat)
+		picker.init(board, GenCaptures, Move{}, nil, ply)
+	}
+
+	bestScore := standPat
+	for {
+		move, ok := picker.nextMove()
+		if !ok {
+			break
+		}
+		if !inCheck {
+			// Captures that lose material can't raise the score above standing pat.
+			if isLosingCapture(board, move) {
+				continue
+			}
+			// Delta pruning: skip captures that can't bring the score back to alpha, even when a positional
+			// margin is added to the material won.
+			if standPat+captureGain(board, move)+deltaMargin <= alpha {
+				continue
+			}
+		}
+
+		board.MakeMove(move, w.undo)
+		score := -quiescence(s, w, board, ply+1, -beta, -alpha)
+		board.UnmakeMove(w.undo)
+		bestScore = max(bestScore, score)
+		alpha = max(alpha, bestScore)
+		if alpha >= beta {
+			break
+		}
+	}
+	return bestScore
+}
+
+// terminalScore scores a position without legal moves, ply plies from the root, for the side to move. It is
+// checkmate if the side to move is in check, otherwise stalemate.
+func terminalScore(board *BitBoard, ply int8) int {
+	if board.InCheck() {
+		return -mateScore + int(ply)
 	}
+	return 0
 }
@@ -0,0 +1,114 @@
+package main
+
+// Piece values in centipawns used by the static exchange evaluation, indexed by Piece. The king is worth
+// more than everything else together, so an exchange never ends with it being captured.
+var seePieceValues = [6]int{
+	int(pawnWeight * 100),
+	int(knightWeigh * 100),
+	int(bishopWeight * 100),
+	int(rookWeight * 100),
+	int(queenWeight * 100),
+	int(kingWeight * 100),
+}
+
+// SEE statically evaluates the exchange started by move m. All captures on the destination square are played
+// out, each side capturing with its least valuable piece and stopping when continuing would lose material.
+// Sliders lined up behind other attackers join in as soon as the piece in front of them has captured.
+// The result is the material won in centipawns, from the point of view of the side making the move.
+func SEE(b *BitBoard, m Move) int {
+	origin, destination := m.Origin(), m.Destination()
+	occupied := b.WhiteBB | b.BlackBB
+
+	attacker := b.PieceAt(origin).piece
+	var gain [32]int
+	if victim := b.PieceAt(destination).piece; victim != Empty {
+		gain[0] = seePieceValues[victim]
+	}
+	if m.IsEnPassantMove() {
+		gain[0] = seePieceValues[Pawn]
+		if b.Turn() == White {
+			occupied &^= posToBitBoard(destination - 8)
+		} else {
+			occupied &^= posToBitBoard(destination + 8)
+		}
+	}
+	if promotion := m.Promotion(); promotion != Empty {
+		gain[0] += seePieceValues[promotion] - seePieceValues[Pawn]
+		attacker = promotion
+	}
+
+	occupied &^= posToBitBoard(origin)
+	attackers := attackersTo(b, destination, occupied) & occupied
+	side := b.OppositeTurn()
+
+	depth := 0
+	for {
+		sideAttackers := attackers & colorBoard(b, side)
+		if sideAttackers == 0 {
+			break
+		}
+		depth++
+		// The piece standing on the square after the previous capture is what this capture wins.
+		gain[depth] = seePieceValues[attacker] - gain[depth-1]
+
+		var square int
+		attacker, square = leastValuablePiece(b, sideAttackers)
+		occupied &^= posToBitBoard(square)
+		// Uncover X-ray attackers behind the piece that just captured.
+		attackers |= bishopAttacksFrom(destination, occupied)&(b.BishopBB|b.QueenBB) |
+			rookAttacksFrom(destination, occupied)&(b.RookBB|b.QueenBB)
+		attackers &= occupied
+		side = side.Opposite()
+	}
+
+	// Either side may decline to recapture, so resolve the sequence backwards.
+	for ; depth > 0; depth-- {
+		gain[depth-1] = -max(-gain[depth-1], gain[depth])
+	}
+	return gain[0]
+}
+
+// isLosingCapture reports whether m loses material according to SEE. Capturing a piece that is worth at least
+// as much as the capturing piece can't lose material, so the full exchange is only evaluated for the others.
+func isLosingCapture(b *BitBoard, m Move) bool {
+	if m.IsPromotion() || m.IsEnPassantMove() {
+		return false
+	}
+	victim := b.PieceAt(m.Destination()).piece
+	attacker := b.PieceAt(m.Origin()).piece
+	if victim != Empty && seePieceValues[victim] >= seePieceValues[attacker] {
+		return false
+	}
+	return SEE(b, m) < 0
+}
+
+func leastValuablePiece(b *BitBoard, candidates uint64) (Piece, int) {
+	for piece, pieceBB := range [6]uint64{b.PawnBB, b.KnightBB, b.BishopBB, b.RookBB, b.QueenBB, b.KingBB} {
+		if candidates&pieceBB != 0 {
+			return Piece(piece), LSB(candidates & pieceBB)
+		}
+	}
+	return Empty, -1
+}
+
+func colorBoard(b *BitBoard, color Color) uint64 {
+	if color == White {
+		return b.WhiteBB
+	}
+	return b.BlackBB
+}
+
+// captureGain is the material in centipawns won by m before any recapture.
+func captureGain(b *BitBoard, m Move) int {
+	gain := 0
+	if victim := b.PieceAt(m.Destination()).piece; victim != Empty {
+		gain = seePieceValues[victim]
+	}
+	if m.IsEnPassantMove() {
+		gain = seePieceValues[Pawn]
+	}
+	if promotion := m.Promotion(); promotion != Empty {
+		gain += seePieceValues[promotion] - seePieceValues[Pawn]
+	}
+	return gain
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestSEE(t *testing.T) {
+	pawn, knight, bishop, rook, queen := seePieceValues[Pawn], seePieceValues[Knight], seePieceValues[Bishop],
+		seePieceValues[Rook], seePieceValues[Queen]
+
+	tests := []struct {
+		name string
+		fen  string
+		move string
+		want int
+	}{
+		{"undefended pawn", "1k1r4/1pp4p/p7/4p3/8/P5P1/1PP4P/2K1R3 w - - 0 1", "e1e5", pawn},
+		{"knight takes pawn, x-rayed by rook and queen",
+			"1k1r3q/1ppn3p/p4b2/4p3/8/P2N2P1/1PP1R1BP/2K1Q3 w - - 0 1", "d3e5", pawn - knight},
+		{"doubled rooks against doubled rooks", "k2r4/3r4/8/3p4/8/8/3R4/K2R4 w - - 0 1", "d2d5", pawn - rook},
+		{"rook takes pawn, queen behind it", "k2r4/8/8/3p4/8/8/3R4/K2Q4 w - - 0 1", "d2d5", pawn},
+		{"defended pawn taken by pawn", "4k3/8/2p5/3p4/4P3/8/8/4K3 w - - 0 1", "e4d5", 0},
+		{"queen takes defended pawn", "4k3/8/2p5/3p4/8/8/8/3QK3 w - - 0 1", "d1d5", pawn - queen},
+		{"bishop takes undefended knight", "4k3/8/8/3n4/8/1B6/8/4K3 w - - 0 1", "b3d5", knight},
+		{"rook takes rook defended by a bishop", "4k3/8/1b6/8/3r4/8/8/3RK3 w - - 0 1", "d1d4", rook - rook},
+		{"en passant", "4k3/8/8/3pP3/8/8/8/4K3 w - d6 0 1", "e5d6", pawn},
+		{"quiet move to a safe square", "4k3/8/8/3p4/8/8/8/2N1K3 w - - 0 1", "c1b3", 0},
+		{"quiet move into a pawn attack", "4k3/8/8/4p3/8/1N6/8/4K3 w - - 0 1", "b3d4", -knight},
+		{"promotion", "4k3/P7/8/8/8/8/8/4K3 w - - 0 1", "a7a8q", queen - pawn},
+		{"defended promotion", "r3k3/1Pn5/8/8/8/8/8/4K3 w - - 0 1", "b7a8q", rook + queen - pawn - queen},
+		{"black captures", "4k3/8/8/3b4/4P3/8/8/4K3 b - - 0 1", "d5e4", pawn},
+		{"black captures defended piece", "4k3/8/8/3q4/4B3/5P2/8/4K3 b - - 0 1", "d5e4", bishop - queen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			board := BoardFromFEN(tt.fen)
+			move, ok := UCIToMove(board, tt.move)
+			if !ok {
+				t.Fatalf("%s is not legal in %s", tt.move, tt.fen)
+			}
+			if got := SEE(&board, move); got != tt.want {
+				t.Errorf("SEE(%s, %s) = %d, want %d", tt.fen, tt.move, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,6 +17,29 @@ type MoveResponse struct {
 	Move       string              `json:"move"`
 	LegalMoves []LegalMoveResponse `json:"legalMoves"`
 	Eval       float64             `json:"eval"`
+	Mate       int                 `json:"mate,omitempty"`
+	Depth      int                 `json:"depth"`
+	SelDepth   int                 `json:"seldepth"`
+	Nodes      uint64              `json:"nodes"`
+	TimeMs     int64               `json:"timeMs"`
+	PV         []string            `json:"pv"`
+	Trace      EvalBreakdown       `json:"trace"`
+}
+
+// AnalysisResponse answers an "analyze <lines> <FEN>" request with the best moves of the position.
+type AnalysisResponse struct {
+	Fen    string                 `json:"fen"`
+	Lines  []AnalysisLineResponse `json:"lines"`
+	Depth  int                    `json:"depth"`
+	Nodes  uint64                 `json:"nodes"`
+	TimeMs int64                  `json:"timeMs"`
+}
+
+type AnalysisLineResponse struct {
+	Move string   `json:"move"`
+	Eval float64  `json:"eval"`
+	Mate int      `json:"mate,omitempty"`
+	PV   []string `json:"pv"`
 }
 
 type LegalMoveResponse struct {
@@ -43,28 +68,66 @@ func StartServer() {
 			}
 			receivedMessageString := string(receivedMessage)
 
+			if strings.HasPrefix(receivedMessageString, "analyze ") {
+				message, err := analysisResponse(strings.TrimPrefix(receivedMessageString, "analyze "))
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+				fmt.Printf("%s sent: %s\n", conn.RemoteAddr(), receivedMessageString)
+				if err = conn.WriteMessage(msgType, message); err != nil {
+					return
+				}
+				continue
+			}
+
 			var legalStates []LegalMoveResponse
 			var nextMove BitBoard
 			var eval float64
+			var mate int
+			var info SearchInfo
 			if receivedMessageString == "init" {
 				nextMove = StartBoard
 			} else if receivedMessageString == "quit" {
 				server.Close()
 			} else {
-				board := BoardFromFEN(receivedMessageString)
-				evaluatedBoard := BestMoveWithoutTimeLimit(&board)
+				fen := receivedMessageString
+				var options SearchOptions
+				if skill, skillFen, found := parseStrengthRequest(receivedMessageString); found {
+					fen = skillFen
+					options.Skill = skill
+				}
+				board := BoardFromFEN(fen)
+				evaluatedBoard := Search(context.Background(), &board, SearchLimits{MoveTime: 5 * time.Second}, options)
 				nextMove = evaluatedBoard.board
 				eval = evaluatedBoard.eval
+				mate = evaluatedBoard.mate
+				info = evaluatedBoard.info
 
 			}
 
-			legalMoves, _ := GenerateLegalStates(&nextMove)
+			legalMoves := GenerateLegalStates(&nextMove)
 
 			for _, move := range legalMoves {
 				lmr := extractLegalMoveResponse(nextMove, move)
 				legalStates = append(legalStates, lmr)
 			}
-			response := &MoveResponse{nextMove.ToFEN(), legalStates, eval}
+			var pv []string
+			for _, move := range info.PV {
+				pv = append(pv, move.ToUCI())
+			}
+			response := &MoveResponse{
+				Move:       nextMove.ToFEN(),
+				LegalMoves: legalStates,
+				Eval:       eval,
+				Mate:       mate,
+				Depth:      info.Depth,
+				SelDepth:   info.SelDepth,
+				Nodes:      info.Nodes,
+				TimeMs:     info.Elapsed.Milliseconds(),
+				PV:         pv,
+				Trace:      EvalTrace(&nextMove),
+			}
 			message, err := json.Marshal(response)
 			if err != nil {
 				fmt.Println(err)
@@ -97,6 +160,56 @@ func StartServer() {
 	}
 }
 
+// parseStrengthRequest parses "skill <level> <FEN>" and "elo <rating> <FEN>" requests, which ask for a move
+// at reduced strength.
+func parseStrengthRequest(request string) (*Skill, string, bool) {
+	kind, rest, _ := strings.Cut(request, " ")
+	if kind != "skill" && kind != "elo" {
+		return nil, "", false
+	}
+	value, fen, found := strings.Cut(rest, " ")
+	number, err := strconv.Atoi(value)
+	if !found || err != nil {
+		return nil, "", false
+	}
+	skill := Skill{Level: min(max(number, 0), maxSkillLevel)}
+	if kind == "elo" {
+		skill = SkillFromElo(number)
+	}
+	return &skill, fen, true
+}
+
+// analysisResponse searches the position of an analysis request, given as the number of lines followed by the FEN.
+func analysisResponse(request string) ([]byte, error) {
+	numLines, fen, found := strings.Cut(request, " ")
+	if !found {
+		return nil, fmt.Errorf("invalid analysis request: %s", request)
+	}
+	lines, err := strconv.Atoi(numLines)
+	if err != nil || lines < 1 {
+		return nil, fmt.Errorf("invalid number of lines: %s", numLines)
+	}
+
+	board := BoardFromFEN(fen)
+	response := &AnalysisResponse{Fen: board.ToFEN()}
+	analysis := Analyze(context.Background(), &board, SearchLimits{MoveTime: 5 * time.Second}, SearchOptions{}, lines)
+	for _, info := range analysis {
+		// Like the eval of a MoveResponse, scores are from white's perspective.
+		line := AnalysisLineResponse{Move: info.PV[0].ToUCI(), Eval: whitePawns(info.Score, board.Turn()), Mate: info.Mate}
+		if board.Turn() == Black {
+			line.Mate = -line.Mate
+		}
+		for _, move := range info.PV {
+			line.PV = append(line.PV, move.ToUCI())
+		}
+		response.Lines = append(response.Lines, line)
+		response.Depth = info.Depth
+		response.Nodes = info.Nodes
+		response.TimeMs = info.Elapsed.Milliseconds()
+	}
+	return json.Marshal(response)
+}
+
 func extractLegalMoveResponse(previous BitBoard, next BitBoard) LegalMoveResponse {
 	whiteKingWasInPosition := previous.KingBB&previous.WhiteBB&posToBitBoard(4) > 0
 	wkCastle := next.KingBB&next.WhiteBB&posToBitBoard(6) > 0
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// Skill levels go from 0, the weakest, to maxSkillLevel, which is full strength.
+const maxSkillLevel = 20
+
+// The range of Elo ratings that the skill levels roughly correspond to.
+const (
+	minSkillElo = 800
+	maxSkillElo = 2400
+)
+
+// Number of candidate moves a weakened engine chooses from.
+const skillLines = 4
+
+// Skill weakens the engine by limiting the depth and the number of nodes of the search, and by playing one of
+// the best moves at random instead of always the best one. The lower the level, the more a worse move is
+// likely to be picked.
+type Skill struct {
+	Level int
+}
+
+// SkillFromElo returns the skill level that plays at about the given rating.
+func SkillFromElo(elo int) Skill {
+	elo = min(max(elo, minSkillElo), maxSkillElo)
+	return Skill{Level: (elo - minSkillElo) * maxSkillLevel / (maxSkillElo - minSkillElo)}
+}
+
+func (sk Skill) limitsStrength() bool {
+	return sk.Level < maxSkillLevel
+}
+
+// limit restricts the limits of a search to what the skill level allows, and makes it search enough lines to
+// choose from. Search only reports the extra lines to OnInfo if MultiPV asked for them.
+func (sk Skill) limit(limits SearchLimits, options SearchOptions) (SearchLimits, SearchOptions) {
+	depth := 1 + sk.Level/2
+	if limits.Depth == 0 || limits.Depth > depth {
+		limits.Depth = depth
+	}
+	nodes := uint64(2000) << (sk.Level / 2)
+	if limits.Nodes == 0 || limits.Nodes > nodes {
+		limits.Nodes = nodes
+	}
+	options.MultiPV = max(options.MultiPV, skillLines)
+	return limits, options
+}
+
+// pickLine chooses one of the lines of a MultiPV search, which are sorted best first. A line is picked with a
+// weight that falls exponentially with how much worse than the best line it scores. The temperature, the
+// score difference that makes a line e times less likely, grows as the level drops.
+func (sk Skill) pickLine(lines []SearchInfo) SearchInfo {
+	temperature := float64(10 + 15*(maxSkillLevel-sk.Level))
+	weights := make([]float64, len(lines))
+	var total float64
+	for i, line := range lines {
+		weights[i] = math.Exp(-float64(lines[0].Score-line.Score) / temperature)
+		total += weights[i]
+	}
+
+	r := rand.Float64() * total
+	for i, weight := range weights {
+		if r < weight {
+			return lines[i]
+		}
+		r -= weight
+	}
+	return lines[0]
+}
@@ -0,0 +1,108 @@
+package main
+
+import "time"
+
+// Safety margin subtracted from the clock so the engine doesn't flag while the move is relayed.
+const moveOverhead = 50 * time.Millisecond
+
+// TimeControl describes the clock of the side to move.
+type TimeControl struct {
+	Remaining time.Duration
+	Increment time.Duration
+	// Moves left until the next time control. Zero means the remaining time has to last the whole game.
+	MovesToGo int
+	// The current full move number, used to estimate how many moves are left in sudden death.
+	MoveNumber int
+	// Upper bound on the time spent on a single move. Zero means no bound.
+	MaxMoveTime time.Duration
+}
+
+// TimeManager decides when iterative deepening should stop. The soft limit is the time we'd like to spend,
+// and is scaled by how stable the best move and score are. The hard limit is never exceeded on purpose.
+type TimeManager struct {
+	start    time.Time
+	soft     time.Duration
+	hard     time.Duration
+	adaptive bool
+
+	iterations       int
+	lastIteration    time.Duration
+	lastIterationEnd time.Duration
+	bestMove         Move
+	stableIterations int
+	previousScore    int
+	scoreDrop        int
+}
+
+// NewFixedTimeManager creates a time manager that searches for exactly the given duration.
+func NewFixedTimeManager(limit time.Duration) *TimeManager {
+	return &TimeManager{start: time.Now(), soft: limit, hard: limit}
+}
+
+func NewTimeManager(tc TimeControl) *TimeManager {
+	movesToGo := tc.MovesToGo
+	if movesToGo <= 0 {
+		// Expect the game to be shorter the longer it has lasted, but always keep a reserve.
+		movesToGo = max(20, 50-tc.MoveNumber)
+	}
+
+	available := max(tc.Remaining-moveOverhead, time.Millisecond)
+	soft := available/time.Duration(movesToGo) + tc.Increment*3/4
+	hard := min(soft*4, available*3/4)
+	if tc.MaxMoveTime > 0 {
+		hard = min(hard, tc.MaxMoveTime)
+	}
+	soft = min(soft, hard)
+
+	return &TimeManager{start: time.Now(), soft: soft, hard: hard, adaptive: true}
+}
+
+// Deadline is the point in time where the search has to be aborted, even in the middle of an iteration.
+func (tm *TimeManager) Deadline() time.Time {
+	return tm.start.Add(tm.hard)
+}
+
+// Update records the result of a completed iteration. The score is in centipawns for the side to move.
+func (tm *TimeManager) Update(bestMove Move, score int) {
+	now := time.Since(tm.start)
+	tm.lastIteration = now - tm.lastIterationEnd
+	tm.lastIterationEnd = now
+
+	if tm.iterations > 0 {
+		if bestMove == tm.bestMove {
+			tm.stableIterations++
+		} else {
+			tm.stableIterations = 0
+		}
+		tm.scoreDrop = max(tm.previousScore-score, 0)
+	}
+	tm.bestMove = bestMove
+	tm.previousScore = score
+	tm.iterations++
+}
+
+// ShouldStop reports whether iterative deepening should stop instead of starting another iteration.
+func (tm *TimeManager) ShouldStop() bool {
+	elapsed := time.Since(tm.start)
+	if elapsed >= tm.hard {
+		return true
+	}
+	// The next iteration takes several times longer than the previous one. Don't start one that
+	// has no chance of finishing before the hard limit. A fixed move time is used in full instead, and the
+	// deadline ends the last iteration.
+	if tm.adaptive && tm.iterations > 0 && elapsed+2*tm.lastIteration > tm.hard {
+		return true
+	}
+	return elapsed >= tm.optimum()
+}
+
+func (tm *TimeManager) optimum() time.Duration {
+	if !tm.adaptive || tm.iterations == 0 {
+		return tm.soft
+	}
+	// A best move that keeps changing needs more time to settle, while a stable one can be played early.
+	stability := []float64{1.6, 1.2, 1.0, 0.8, 0.6}[min(tm.stableIterations, 4)]
+	// Spend extra time when the score is dropping, up to twice the budget for a drop of two pawns.
+	falling := 1.0 + min(float64(tm.scoreDrop)/200, 1.0)
+	return min(time.Duration(float64(tm.soft)*stability*falling), tm.hard)
+}
@@ -1,89 +1,204 @@
 package main
 
-import "sync"
+import (
+	"math"
+	"sync/atomic"
+)
 
+const defaultHashSizeMB = 64
+const entriesPerBucket = 4
+
+// Entries written in the last recentGenerations searches belong to searches that may still be running. Searches
+// that run at the same time, like the games and ponder searches of the Lichess bot, each start a generation,
+// so a running search can't rely on its entries having the current generation.
+const recentGenerations = 4
+
+type Bound uint8
+
+const (
+	BoundNone Bound = iota
+	// The score is at least the stored value (the search failed high).
+	BoundLower
+	// The score is at most the stored value (the search failed low).
+	BoundUpper
+	BoundExact
+)
+
+// TranspositionTable is a fixed-size hash table shared by all search goroutines without locks.
+// Every entry is two words: the packed data, and the position key XOR-ed with the data. A reader only
+// accepts an entry if both words belong to the same write, so torn writes from concurrent goroutines are
+// detected instead of returning a score for the wrong position.
 type TranspositionTable struct {
-	lower sync.Map
-	upper sync.Map
-	exact sync.Map
+	buckets    []ttBucket
+	mask       uint64
+	generation atomic.Uint32
 }
 
-func newTranspositionTable() *TranspositionTable {
-	return &TranspositionTable{
-		lower: sync.Map{},
-		upper: sync.Map{},
-		exact: sync.Map{},
-	}
-
+// A bucket is 64 bytes, so it fits in a single cache line.
+type ttBucket struct {
+	entries [entriesPerBucket]ttEntry
 }
 
-func (tt *TranspositionTable) Clear() {
-	tt.lower.Clear()
-	tt.upper.Clear()
-	tt.exact.Clear()
+type ttEntry struct {
+	keyXorData uint64
+	// Bit   0-16: best move
+	// Bit  17-36: score in centipawns, two's complement
+	// Bit  37-44: remaining depth
+	// Bit  45-46: bound type
+	// Bit  47-54: generation the entry was written in
+	data uint64
 }
 
 type TTEntry struct {
-	score float64
-	depth int8
+	Score int
+	Depth int8
+	Bound Bound
+	Move  Move
 }
 
-type TTResult struct {
-	lower float64
-	upper float64
+func newTranspositionTable(sizeMB int) *TranspositionTable {
+	tt := &TranspositionTable{}
+	tt.Resize(sizeMB)
+	return tt
 }
 
-func (tt *TranspositionTable) getExact(board *BitBoard, depth int8) (bool, float64) {
-	entry, found := tt.exact.Load(board.Hash())
-	if !found {
-		return false, 0
-	}
-	ttEntry := entry.(TTEntry)
-	if ttEntry.depth >= depth {
-		return true, ttEntry.score
+// Resize reallocates the table to the largest power of two number of buckets that fits in sizeMB.
+// All entries are lost.
+func (tt *TranspositionTable) Resize(sizeMB int) {
+	bucketSize := uint64(16 * entriesPerBucket)
+	numBuckets := uint64(1)
+	for numBuckets*2*bucketSize <= uint64(max(sizeMB, 1))<<20 {
+		numBuckets *= 2
 	}
-	return false, 0
-}
-
-func (tt *TranspositionTable) getUpperAndLower(board *BitBoard, depth int8) TTResult {
-	hash := board.Hash()
-	
-	lower := -1000.0
-	lowerEntry, found := tt.lower.Load(hash)
-	if found {
-		ttEntry := lowerEntry.(TTEntry)
-		if ttEntry.depth >= depth {
-			lower = ttEntry.score
+	tt.buckets = make([]ttBucket, numBuckets)
+	tt.mask = numBuckets - 1
+}
+
+func (tt *TranspositionTable) Clear() {
+	clear(tt.buckets)
+	tt.generation.Store(0)
+}
+
+// NewSearch ages the table. It is called for every root search. Entries from earlier searches are kept, but
+// are the first to be replaced.
+func (tt *TranspositionTable) NewSearch() {
+	tt.generation.Add(1)
+}
+
+func (tt *TranspositionTable) Probe(key uint64) (TTEntry, bool) {
+	bucket := &tt.buckets[key&tt.mask]
+	for i := range bucket.entries {
+		entry := &bucket.entries[i]
+		data := atomic.LoadUint64(&entry.data)
+		if atomic.LoadUint64(&entry.keyXorData)^data == key && data != 0 {
+			return unpackEntry(data), true
 		}
 	}
-	
-	upper := 1000.0
-	upperEntry, found := tt.upper.Load(hash)
-	if found {
-		ttEntry := upperEntry.(TTEntry)
-		if ttEntry.depth >= depth {
-			upper = ttEntry.score
+	return TTEntry{}, false
+}
+
+// Store writes an entry. If the position is already stored, it is only overwritten by deeper or exact
+// results. Otherwise the entry with the lowest depth, where each generation of age costs 8 plies, is replaced.
+func (tt *TranspositionTable) Store(key uint64, score int, depth int8, bound Bound, move Move) {
+	generation := uint8(tt.generation.Load())
+	bucket := &tt.buckets[key&tt.mask]
+
+	var replace *ttEntry
+	replaceValue := math.MaxInt
+	for i := range bucket.entries {
+		entry := &bucket.entries[i]
+		data := atomic.LoadUint64(&entry.data)
+		if data == 0 || atomic.LoadUint64(&entry.keyXorData)^data == key {
+			if data != 0 {
+				old := unpackEntry(data)
+				if bound != BoundExact && depth < old.Depth-2 && entryAge(data, generation) < recentGenerations {
+					return
+				}
+				// Keep the old best move if this search didn't produce one.
+				if move.bits == 0 {
+					move = old.Move
+				}
+			}
+			replace = entry
+			break
+		}
+
+		value := int(unpackEntry(data).Depth) - 8*entryAge(data, generation)
+		if value < replaceValue {
+			replace = entry
+			replaceValue = value
 		}
 	}
 
-	return TTResult{lower: lower, upper: upper}
+	data := packEntry(score, depth, bound, move, generation)
+	atomic.StoreUint64(&replace.data, data)
+	atomic.StoreUint64(&replace.keyXorData, key^data)
 }
 
-func (tt *TranspositionTable) setExact(board *BitBoard, score float64, depth int8) {
-	hash := board.Hash()
-	existingEntry, existingFound := tt.exact.Load(hash)
-	if existingFound {
-		ttEntry := existingEntry.(TTEntry)
-		if ttEntry.depth >= depth {
-			return
+// HashFull estimates the permille of the table used by recent searches by sampling the first buckets.
+func (tt *TranspositionTable) HashFull() int {
+	generation := uint8(tt.generation.Load())
+	samples := min(len(tt.buckets), 1000/entriesPerBucket)
+	used := 0
+	for i := range samples {
+		for j := range tt.buckets[i].entries {
+			data := atomic.LoadUint64(&tt.buckets[i].entries[j].data)
+			if data != 0 && entryAge(data, generation) < recentGenerations {
+				used++
+			}
 		}
 	}
-	tt.exact.Store(hash, TTEntry{score: score, depth: depth})
+	return used * 1000 / (samples * entriesPerBucket)
+}
+
+func packEntry(score int, depth int8, bound Bound, move Move, generation uint8) uint64 {
+	return uint64(move.bits)&0x1FFFF |
+		uint64(score)&0xFFFFF<<17 |
+		uint64(uint8(depth))<<37 |
+		uint64(bound)&0x3<<45 |
+		uint64(generation)<<47
+}
+
+func unpackEntry(data uint64) TTEntry {
+	// Shift the 20 bit score to the top and back to sign extend it.
+	score := int64(data<<27) >> 44
+	return TTEntry{
+		Score: int(score),
+		Depth: int8(uint8(data >> 37)),
+		Bound: Bound(data >> 45 & 0x3),
+		Move:  Move{bits: uint32(data & 0x1FFFF)},
+	}
+}
+
+// scoreToTT turns a mate score relative to the root into one relative to the node at ply, since the same
+// position can be reached at different plies. Other scores don't depend on the ply.
+func scoreToTT(score int, ply int8) int {
+	switch {
+	case score >= mateThreshold:
+		return score + int(ply)
+	case score <= -mateThreshold:
+		return score - int(ply)
+	}
+	return score
 }
-func (tt *TranspositionTable) setLower(board *BitBoard, score float64, depth int8) {
-	tt.lower.Store(board.Hash(), TTEntry{score: score, depth: depth})
+
+// scoreFromTT turns a stored mate score back into one relative to the root.
+func scoreFromTT(score int, ply int8) int {
+	switch {
+	case score >= mateThreshold:
+		return score - int(ply)
+	case score <= -mateThreshold:
+		return score + int(ply)
+	}
+	return score
+}
+
+func entryGeneration(data uint64) uint8 {
+	return uint8(data >> 47)
 }
 
-func (tt *TranspositionTable) setUpper(board *BitBoard, score float64, depth int8) {
-	tt.upper.Store(board.Hash(), TTEntry{score: score, depth: depth})
+// entryAge is the number of searches started since the entry was written. The generation wraps around, so
+// generations are compared by their distance.
+func entryAge(data uint64, generation uint8) int {
+	return int(generation - entryGeneration(data))
 }
@@ -0,0 +1,106 @@
+package main
+
+import "testing"
+
+func TestTranspositionTableRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		score int
+		depth int8
+		bound Bound
+		move  Move
+	}{
+		{"positive score", 137, 12, BoundExact, Move{bits: 0x0C1C}},
+		{"negative score", -4321, 5, BoundUpper, Move{bits: 0x0345}},
+		{"zero score without move", 0, 1, BoundLower, Move{}},
+		{"negative depth", -25, -1, BoundLower, Move{bits: 0x1FFFF}},
+		{"largest depth", 250, maxDepth, BoundExact, Move{bits: 0x10000}},
+		{"mate score", mateScore - 7, 20, BoundExact, Move{bits: 0x0FFF}},
+		{"mated score", -(mateScore - 12), 9, BoundUpper, Move{bits: 0x0001}},
+		{"largest scores", infinity, 3, BoundLower, Move{bits: 0x2222}},
+		{"smallest scores", -infinity, 3, BoundUpper, Move{bits: 0x3333}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := newTranspositionTable(1)
+			key := 0x9E3779B97F4A7C15 * uint64(i+1)
+			table.Store(key, tt.score, tt.depth, tt.bound, tt.move)
+
+			entry, found := table.Probe(key)
+			if !found {
+				t.Fatalf("Probe(%#x) found no entry", key)
+			}
+			want := TTEntry{Score: tt.score, Depth: tt.depth, Bound: tt.bound, Move: tt.move}
+			if entry != want {
+				t.Errorf("Probe(%#x) = %+v, want %+v", key, entry, want)
+			}
+			if _, found := table.Probe(key ^ 1); found {
+				t.Errorf("Probe(%#x) found an entry stored for %#x", key^1, key)
+			}
+		})
+	}
+}
+
+func TestTranspositionTableGenerations(t *testing.T) {
+	tests := []struct {
+		name string
+		// Searches started between storing the deep and the shallow entry.
+		searches int
+		want     int8
+	}{
+		{"same search keeps the deeper entry", 0, 10},
+		{"recent search keeps the deeper entry", recentGenerations - 1, 10},
+		{"old entry is replaced", recentGenerations, 2},
+		{"generation wraps around", 256, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := newTranspositionTable(1)
+			const key = 0x123456789ABCDEF
+			table.Store(key, 50, 10, BoundLower, Move{bits: 0x0C1C})
+			for range tt.searches {
+				table.NewSearch()
+			}
+			table.Store(key, 60, 2, BoundLower, Move{})
+
+			entry, _ := table.Probe(key)
+			if entry.Depth != tt.want {
+				t.Errorf("depth after %d searches = %d, want %d", tt.searches, entry.Depth, tt.want)
+			}
+			if entry.Move != (Move{bits: 0x0C1C}) {
+				t.Errorf("move after %d searches = %#x, want the stored best move", tt.searches, entry.Move.bits)
+			}
+		})
+	}
+}
+
+func TestScoreToTT(t *testing.T) {
+	tests := []struct {
+		name     string
+		score    int
+		ply      int8
+		stored   int
+		readPly  int8
+		readBack int
+	}{
+		{"normal score", 250, 6, 250, 3, 250},
+		{"negative score", -250, 6, -250, 3, -250},
+		{"mate", mateScore - 9, 4, mateScore - 5, 2, mateScore - 7},
+		{"mated", -(mateScore - 9), 4, -(mateScore - 5), 2, -(mateScore - 7)},
+		{"mate at the root", mateScore - 3, 0, mateScore - 3, 0, mateScore - 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := scoreToTT(tt.score, tt.ply)
+			if stored != tt.stored {
+				t.Errorf("scoreToTT(%d, %d) = %d, want %d", tt.score, tt.ply, stored, tt.stored)
+			}
+			if got := scoreFromTT(stored, tt.ply); got != tt.score {
+				t.Errorf("scoreFromTT(%d, %d) = %d, want %d", stored, tt.ply, got, tt.score)
+			}
+			if got := scoreFromTT(stored, tt.readPly); got != tt.readBack {
+				t.Errorf("scoreFromTT(%d, %d) = %d, want %d", stored, tt.readPly, got, tt.readBack)
+			}
+		})
+	}
+}
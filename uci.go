@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const engineName = "go-chess"
+const engineAuthor = "sberglann"
+
+// Upper bound of the Threads option.
+const maxThreads = 256
+
+type UCIEngine struct {
+	board BitBoard
+	// Keys of the positions before board in the game, oldest first.
+	history []uint64
+	out     io.Writer
+	outMu   sync.Mutex
+	// Search features toggled with setoption. OnInfo is set for every search.
+	options SearchOptions
+	// Playing strength. UCI_LimitStrength with UCI_Elo takes precedence over the skill level.
+	skillLevel    int
+	limitStrength bool
+	elo           int
+
+	// Cancels the running search. An infinite search also waits for it before sending bestmove.
+	cancel context.CancelFunc
+	search sync.WaitGroup
+	// Turns the running "go ponder" search into a normal search. Nil unless the engine is pondering.
+	ponderHit func()
+}
+
+type UCIGoParams struct {
+	WTime     time.Duration
+	BTime     time.Duration
+	WInc      time.Duration
+	BInc      time.Duration
+	MovesToGo int
+	MoveTime  time.Duration
+	Depth     int
+	Nodes     uint64
+	Mate      int
+	Infinite  bool
+	Ponder    bool
+}
+
+func NewUCIEngine(out io.Writer) *UCIEngine {
+	return &UCIEngine{
+		board:      StartBoard,
+		out:        out,
+		options:    SearchOptions{Threads: 1},
+		skillLevel: maxSkillLevel,
+		elo:        maxSkillElo,
+	}
+}
+
+func StartUCI() {
+	engine := NewUCIEngine(os.Stdout)
+	engine.Run(os.Stdin)
+}
+
+func (e *UCIEngine) send(format string, args ...interface{}) {
+	e.outMu.Lock()
+	defer e.outMu.Unlock()
+	fmt.Fprintf(e.out, format+"\n", args...)
+}
+
+// Run reads UCI commands from in until "quit" is received or the input is closed.
+func (e *UCIEngine) Run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "uci":
+			e.send("id name %s", engineName)
+			e.send("id author %s", engineAuthor)
+			e.send("option name Hash type spin default %d min 1 max 4096", defaultHashSizeMB)
+			e.send("option name Threads type spin default 1 min 1 max %d", maxThreads)
+			e.send("option name NullMove type check default true")
+			e.send("option name LMR type check default true")
+			e.send("option name Futility type check default true")
+			e.send("option name CheckExtensions type check default true")
+			e.send("option name Contempt type spin default 0 min -1000 max 1000")
+			e.send("option name Ponder type check default false")
+			e.send("option name MultiPV type spin default 1 min 1 max %d", maxMoves)
+			e.send("option name Skill Level type spin default %d min 0 max %d", maxSkillLevel, maxSkillLevel)
+			e.send("option name UCI_LimitStrength type check default false")
+			e.send("option name UCI_Elo type spin default %d min %d max %d", maxSkillElo, minSkillElo, maxSkillElo)
+			e.send("uciok")
+		case "isready":
+			e.send("readyok")
+		case "ucinewgame":
+			e.waitForSearch()
+			e.board = StartBoard
+			e.history = nil
+			transpositionTable.Clear()
+		case "position":
+			e.waitForSearch()
+			e.handlePosition(fields[1:])
+		case "go":
+			e.waitForSearch()
+			e.handleGo(fields[1:])
+		case "stop":
+			e.waitForSearch()
+		case "quit":
+			e.waitForSearch()
+			return
+		case "setoption":
+			e.waitForSearch()
+			e.handleSetOption(fields[1:])
+		case "ponderhit":
+			if e.ponderHit != nil {
+				e.ponderHit()
+				e.ponderHit = nil
+			}
+		case "debug", "register":
+			// Neither debug output nor registration is supported.
+		default:
+			e.send("info string unknown command: %s", fields[0])
+		}
+	}
+	e.waitForSearch()
+}
+
+// waitForSearch stops any running search and blocks until its bestmove has been sent.
+func (e *UCIEngine) waitForSearch() {
+	e.ponderHit = nil
+	if e.cancel != nil {
+		e.cancel()
+		e.cancel = nil
+	}
+	e.search.Wait()
+}
+
+// handleSetOption parses "setoption name <id> [value <x>]". Option names may contain spaces.
+func (e *UCIEngine) handleSetOption(args []string) {
+	var name, value []string
+	target := &name
+	for _, arg := range args {
+		switch arg {
+		case "name":
+			target = &name
+		case "value":
+			target = &value
+		default:
+			*target = append(*target, arg)
+		}
+	}
+
+	switch strings.ToLower(strings.Join(name, " ")) {
+	case "hash":
+		sizeMB, err := strconv.Atoi(strings.Join(value, " "))
+		if err != nil || sizeMB < 1 {
+			e.send("info string invalid hash size")
+			return
+		}
+		transpositionTable.Resize(sizeMB)
+	case "threads":
+		threads, err := strconv.Atoi(strings.Join(value, " "))
+		if err != nil || threads < 1 || threads > maxThreads {
+			e.send("info string invalid thread count")
+			return
+		}
+		e.options.Threads = threads
+	case "nullmove":
+		e.setCheckOption(&e.options.DisableNullMove, value)
+	case "lmr":
+		e.setCheckOption(&e.options.DisableLMR, value)
+	case "futility":
+		e.setCheckOption(&e.options.DisableFutility, value)
+	case "checkextensions":
+		e.setCheckOption(&e.options.DisableCheckExtensions, value)
+	case "multipv":
+		multiPV, err := strconv.Atoi(strings.Join(value, " "))
+		if err != nil || multiPV < 1 || multiPV > maxMoves {
+			e.send("info string invalid MultiPV")
+			return
+		}
+		e.options.MultiPV = multiPV
+	case "skill level":
+		level, err := strconv.Atoi(strings.Join(value, " "))
+		if err != nil || level < 0 || level > maxSkillLevel {
+			e.send("info string invalid skill level")
+			return
+		}
+		e.skillLevel = level
+	case "uci_limitstrength":
+		limit, err := strconv.ParseBool(strings.Join(value, " "))
+		if err != nil {
+			e.send("info string invalid check value: %s", strings.Join(value, " "))
+			return
+		}
+		e.limitStrength = limit
+	case "uci_elo":
+		elo, err := strconv.Atoi(strings.Join(value, " "))
+		if err != nil || elo < minSkillElo || elo > maxSkillElo {
+			e.send("info string invalid Elo")
+			return
+		}
+		e.elo = elo
+	case "ponder":
+		// The GUI decides when to ponder, so there is nothing to set up.
+	case "contempt":
+		contempt, err := strconv.Atoi(strings.Join(value, " "))
+		if err != nil || contempt < -1000 || contempt > 1000 {
+			e.send("info string invalid contempt")
+			return
+		}
+		e.options.Contempt = contempt
+	default:
+		e.send("info string unknown option: %s", strings.Join(name, " "))
+	}
+}
+
+// setCheckOption sets a Disable* search option from the value of a check option that enables the feature.
+func (e *UCIEngine) setCheckOption(disable *bool, value []string) {
+	enabled, err := strconv.ParseBool(strings.Join(value, " "))
+	if err != nil {
+		e.send("info string invalid check value: %s", strings.Join(value, " "))
+		return
+	}
+	*disable = !enabled
+}
+
+func (e *UCIEngine) handlePosition(args []string) {
+	if len(args) == 0 {
+		return
+	}
+
+	var board BitBoard
+	var movesIndex int
+	switch args[0] {
+	case "startpos":
+		board = StartBoard
+		movesIndex = 1
+	case "fen":
+		movesIndex = 1
+		for movesIndex < len(args) && args[movesIndex] != "moves" {
+			movesIndex++
+		}
+		board = BoardFromFEN(strings.Join(args[1:movesIndex], " "))
+	default:
+		e.send("info string invalid position command")
+		return
+	}
+
+	var history []uint64
+	if movesIndex < len(args) && args[movesIndex] == "moves" {
+		for _, uciMove := range args[movesIndex+1:] {
+			next, ok := ApplyUCIMove(board, uciMove)
+			if !ok {
+				e.send("info string illegal move %s in position %s", uciMove, board.ToFEN())
+				break
+			}
+			history = append(history, board.Hash())
+			board = next
+		}
+	}
+	e.board = board
+	e.history = history
+}
+
+func ParseUCIGoParams(args []string) UCIGoParams {
+	var params UCIGoParams
+	intArg := func(i int) int {
+		if i+1 >= len(args) {
+			return 0
+		}
+		value, _ := strconv.Atoi(args[i+1])
+		return value
+	}
+	millis := func(i int) time.Duration {
+		return time.Duration(intArg(i)) * time.Millisecond
+	}
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "wtime":
+			params.WTime = millis(i)
+			i++
+		case "btime":
+			params.BTime = millis(i)
+			i++
+		case "winc":
+			params.WInc = millis(i)
+			i++
+		case "binc":
+			params.BInc = millis(i)
+			i++
+		case "movestogo":
+			params.MovesToGo = intArg(i)
+			i++
+		case "movetime":
+			params.MoveTime = millis(i)
+			i++
+		case "depth":
+			params.Depth = intArg(i)
+			i++
+		case "nodes":
+			params.Nodes = uint64(intArg(i))
+			i++
+		case "mate":
+			params.Mate = intArg(i)
+			i++
+		case "infinite":
+			params.Infinite = true
+		case "ponder":
+			params.Ponder = true
+		}
+	}
+	return params
+}
+
+// searchLimits converts the go parameters into limits for the side to move.
+func (p UCIGoParams) searchLimits(board *BitBoard) SearchLimits {
+	limits := SearchLimits{
+		Depth:    p.Depth,
+		Nodes:    p.Nodes,
+		Mate:     p.Mate,
+		Infinite: p.Infinite,
+		Ponder:   p.Ponder,
+	}
+	if p.MoveTime > 0 {
+		limits.MoveTime = max(p.MoveTime-moveOverhead, time.Millisecond)
+	}
+
+	remaining, increment := p.WTime, p.WInc
+	if board.Turn() == Black {
+		remaining, increment = p.BTime, p.BInc
+	}
+	limits.Clock = TimeControl{
+		Remaining:  remaining,
+		Increment:  increment,
+		MovesToGo:  p.MovesToGo,
+		MoveNumber: board.TurnCount(),
+	}
+	return limits
+}
+
+func (e *UCIEngine) handleGo(args []string) {
+	params := ParseUCIGoParams(args)
+	board := e.board
+	limits := params.searchLimits(&board)
+
+	options := e.options
+	options.History = e.history
+	options.Skill = e.skill()
+	options.OnInfo = func(info SearchInfo) {
+		e.sendInfo(info)
+	}
+
+	// On ponderhit, the search continues with the time limits of the go command, counted from the ponderhit.
+	hit := make(chan SearchLimits, 1)
+	hitReceived := make(chan struct{})
+	if limits.Ponder {
+		options.PonderHit = hit
+		e.ponderHit = func() {
+			hitLimits := limits
+			hitLimits.Ponder = false
+			hit <- hitLimits
+			close(hitReceived)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+
+	e.search.Add(1)
+	go func() {
+		defer e.search.Done()
+		result := Search(ctx, &board, limits, options)
+
+		// In infinite mode the GUI expects bestmove only after it has sent stop, and when pondering only after
+		// ponderhit or stop.
+		if limits.Infinite {
+			<-ctx.Done()
+		} else if limits.Ponder {
+			select {
+			case <-hitReceived:
+			case <-ctx.Done():
+			}
+		}
+
+		if result.move.bits == 0 {
+			e.send("bestmove 0000")
+			return
+		}
+		// The second move of the PV is the reply the GUI can let the engine ponder on.
+		if len(result.pv) >= 2 {
+			e.send("bestmove %s ponder %s", result.move.ToUCI(), result.pv[1].ToUCI())
+			return
+		}
+		e.send("bestmove %s", result.move.ToUCI())
+	}()
+}
+
+// skill returns the playing strength set by the options, or nil for full strength.
+func (e *UCIEngine) skill() *Skill {
+	skill := Skill{Level: e.skillLevel}
+	if e.limitStrength {
+		skill = SkillFromElo(e.elo)
+	}
+	if !skill.limitsStrength() {
+		return nil
+	}
+	return &skill
+}
+
+func (e *UCIEngine) sendInfo(info SearchInfo) {
+	e.send("info depth %d seldepth %d multipv %d score %s nodes %d nps %d hashfull %d time %d pv %s",
+		info.Depth, info.SelDepth, info.MultiPV, uciScore(info), info.Nodes, info.NPS,
+		info.HashFull, info.Elapsed.Milliseconds(), info.PVString())
+}
+
+// uciScore formats the score as "mate <moves>" when a mate has been found, and as "cp <centipawns>" otherwise.
+func uciScore(info SearchInfo) string {
+	if info.Mate != 0 {
+		return fmt.Sprintf("mate %d", info.Mate)
+	}
+	return fmt.Sprintf("cp %d", info.Score)
+}
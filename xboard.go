@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errThinkingAbandoned cancels a search whose move must not be played, because a command like "force" or
+// "new" changed the game while the engine was thinking.
+var errThinkingAbandoned = errors.New("thinking abandoned")
+
+// XBoardEngine implements the Chess Engine Communication Protocol (CECP) used by XBoard and WinBoard.
+// Searches run in the background, so "?" can make the engine move at once.
+type XBoardEngine struct {
+	board   BitBoard
+	history []BitBoard
+	out     io.Writer
+	outMu   sync.Mutex
+
+	// In force mode the engine only records moves and never starts thinking on its own.
+	force bool
+	// Whether thinking output is sent while searching.
+	post        bool
+	engineColor Color
+
+	// Time control as set by "level", "st" and "sd".
+	movesPerSession int
+	increment       time.Duration
+	fixedMoveTime   time.Duration
+	depthLimit      int8
+
+	// Clocks as reported by "time" and "otim".
+	engineTime   time.Duration
+	opponentTime time.Duration
+
+	// Cancels the running search. With errThinkingAbandoned as the cause, the search ends without a move.
+	cancel context.CancelCauseFunc
+	search sync.WaitGroup
+}
+
+func NewXBoardEngine(out io.Writer) *XBoardEngine {
+	e := &XBoardEngine{out: out}
+	e.reset()
+	return e
+}
+
+func StartXBoard() {
+	engine := NewXBoardEngine(os.Stdout)
+	engine.Run(os.Stdin)
+}
+
+func (e *XBoardEngine) send(format string, args ...interface{}) {
+	e.outMu.Lock()
+	defer e.outMu.Unlock()
+	fmt.Fprintf(e.out, format+"\n", args...)
+}
+
+func (e *XBoardEngine) reset() {
+	e.board = StartBoard
+	e.history = nil
+	e.force = false
+	e.engineColor = Black
+	e.fixedMoveTime = 0
+	e.depthLimit = maxDepth
+}
+
+// Run reads CECP commands from in until "quit" is received or the input is closed.
+func (e *XBoardEngine) Run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "xboard", "accepted", "rejected", "random", "hard", "easy", "computer", "name", "rating", "ics":
+			// Nothing to do, either because the command is informational or the feature is unsupported.
+		case "protover":
+			e.send("feature myname=\"%s\" setboard=1 usermove=1 ping=1 playother=1 sigint=0 sigterm=0 colors=0 analyze=0 done=1", engineName)
+		case "post":
+			e.post = true
+		case "nopost":
+			e.post = false
+		case "?":
+			e.moveNow()
+		case "new":
+			e.stopThinking()
+			e.reset()
+		case "force":
+			e.stopThinking()
+			e.force = true
+		case "go":
+			e.stopThinking()
+			e.force = false
+			e.engineColor = e.board.Turn()
+			e.think()
+		case "playother":
+			e.stopThinking()
+			e.force = false
+			e.engineColor = e.board.Turn().Opposite()
+		case "usermove":
+			if len(fields) < 2 {
+				e.send("Error (missing move): usermove")
+				continue
+			}
+			e.stopThinking()
+			e.handleUserMove(fields[1])
+		case "level":
+			e.handleLevel(fields[1:])
+		case "st":
+			if len(fields) > 1 {
+				seconds, _ := strconv.ParseFloat(fields[1], 64)
+				e.fixedMoveTime = time.Duration(seconds * float64(time.Second))
+			}
+		case "sd":
+			if len(fields) > 1 {
+				depth, _ := strconv.Atoi(fields[1])
+				e.depthLimit = int8(max(1, min(depth, maxDepth)))
+			}
+		case "time":
+			e.engineTime = parseCentiseconds(fields)
+		case "otim":
+			e.opponentTime = parseCentiseconds(fields)
+		case "undo":
+			e.stopThinking()
+			e.undo(1)
+		case "remove":
+			e.stopThinking()
+			e.undo(2)
+		case "setboard":
+			e.stopThinking()
+			e.board = BoardFromFEN(strings.Join(fields[1:], " "))
+			e.history = nil
+		case "result":
+			e.stopThinking()
+			e.force = true
+		case "ping":
+			if len(fields) > 1 {
+				e.send("pong %s", fields[1])
+			}
+		case "quit":
+			e.stopThinking()
+			return
+		default:
+			e.send("Error (unknown command): %s", fields[0])
+		}
+	}
+	e.stopThinking()
+}
+
+// moveNow stops the running search, which then plays the best move it has found.
+func (e *XBoardEngine) moveNow() {
+	if e.cancel != nil {
+		e.cancel(nil)
+	}
+}
+
+// stopThinking abandons the running search without playing a move, and blocks until it has ended. A search
+// that was already told to move now still plays its move.
+func (e *XBoardEngine) stopThinking() {
+	if e.cancel != nil {
+		e.cancel(errThinkingAbandoned)
+		e.cancel = nil
+	}
+	e.search.Wait()
+}
+
+func (e *XBoardEngine) handleUserMove(uciMove string) {
+	next, ok := ApplyUCIMove(e.board, uciMove)
+	if !ok {
+		e.send("Illegal move: %s", uciMove)
+		return
+	}
+	e.history = append(e.history, e.board)
+	e.board = next
+
+	if !e.force && e.board.Turn() == e.engineColor {
+		e.think()
+	}
+}
+
+// handleLevel parses "level MPS BASE INC". BASE is not needed since xboard sends "time" before every move.
+func (e *XBoardEngine) handleLevel(args []string) {
+	if len(args) < 3 {
+		e.send("Error (too few arguments): level")
+		return
+	}
+	e.movesPerSession, _ = strconv.Atoi(args[0])
+	increment, _ := strconv.ParseFloat(args[2], 64)
+	e.increment = time.Duration(increment * float64(time.Second))
+	e.fixedMoveTime = 0
+}
+
+func (e *XBoardEngine) undo(plies int) {
+	for range plies {
+		if len(e.history) == 0 {
+			return
+		}
+		e.board = e.history[len(e.history)-1]
+		e.history = e.history[:len(e.history)-1]
+	}
+}
+
+func (e *XBoardEngine) searchLimits() SearchLimits {
+	limits := SearchLimits{Depth: int(e.depthLimit)}
+	if e.fixedMoveTime > 0 {
+		limits.MoveTime = max(e.fixedMoveTime-moveOverhead, time.Millisecond)
+		return limits
+	}
+	if e.engineTime <= 0 {
+		limits.MoveTime = 5 * time.Second
+		return limits
+	}
+
+	var movesToGo int
+	if e.movesPerSession > 0 {
+		movesPlayed := len(e.history) / 2
+		movesToGo = e.movesPerSession - movesPlayed%e.movesPerSession
+	}
+	limits.Clock = TimeControl{
+		Remaining:  e.engineTime,
+		Increment:  e.increment,
+		MovesToGo:  movesToGo,
+		MoveNumber: e.board.TurnCount(),
+	}
+	return limits
+}
+
+// xboardScore is the score of the thinking output. Mates are reported as 100000 plus the number of moves to
+// mate, negative when the engine gets mated, which is how CECP GUIs recognize them.
+func xboardScore(info SearchInfo) int {
+	switch {
+	case info.Mate > 0:
+		return 100000 + info.Mate
+	case info.Mate < 0:
+		return -100000 + info.Mate
+	}
+	return info.Score
+}
+
+// think starts searching the current position. The search runs until its limits are reached or "?" is
+// received, and then plays its move.
+func (e *XBoardEngine) think() {
+	board := e.board
+	var options SearchOptions
+	for _, previous := range e.history {
+		options.History = append(options.History, previous.Hash())
+	}
+	if e.post {
+		options.OnInfo = func(info SearchInfo) {
+			// Thinking output: ply, score in centipawns, time in centiseconds, nodes and the PV.
+			e.send("%d %d %d %d %s", info.Depth, xboardScore(info),
+				info.Elapsed.Milliseconds()/10, info.Nodes, info.PVString())
+		}
+	}
+	limits := e.searchLimits()
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	e.cancel = cancel
+
+	e.search.Add(1)
+	go func() {
+		defer e.search.Done()
+		result := Search(ctx, &board, limits, options)
+		if context.Cause(ctx) == errThinkingAbandoned {
+			return
+		}
+		if result.move.bits == 0 {
+			// No legal moves left, so the game is over by checkmate or stalemate.
+			if !board.InCheck() {
+				e.send("1/2-1/2 {Stalemate}")
+			} else if board.Turn() == White {
+				e.send("0-1 {Black mates}")
+			} else {
+				e.send("1-0 {White mates}")
+			}
+			return
+		}
+
+		// The commands that read or change the game wait for the search first, so it can update it here.
+		e.history = append(e.history, e.board)
+		e.board = result.board
+		e.send("move %s", result.move.ToUCI())
+	}()
+}
+
+func parseCentiseconds(fields []string) time.Duration {
+	if len(fields) < 2 {
+		return 0
+	}
+	centiseconds, _ := strconv.Atoi(fields[1])
+	return time.Duration(centiseconds) * 10 * time.Millisecond
+}
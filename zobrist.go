@@ -0,0 +1,96 @@
+package main
+
+// Zobrist keys are made of 768 piece-square keys, 4 castling keys, 8 en passant file keys and one key for
+// white to move. The values are generated from a fixed seed, so keys are stable between runs.
+const (
+	zobristCastlingOffset  = 768
+	zobristEnPassantOffset = 772
+	zobristTurnOffset      = 780
+)
+
+var zobristRandoms = generateZobristRandoms()
+
+const notAFile = uint64(0xfefefefefefefefe)
+const notHFile = uint64(0x7f7f7f7f7f7f7f7f)
+
+func generateZobristRandoms() [781]uint64 {
+	// SplitMix64, see https://prng.di.unimi.it/splitmix64.c
+	var randoms [781]uint64
+	state := uint64(0x9e3779b97f4a7c15)
+	for i := range randoms {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		randoms[i] = z ^ (z >> 31)
+	}
+	return randoms
+}
+
+// zobristPiece returns the key for a piece on a square. The pieces are ordered as black pawn, white pawn,
+// black knight, white knight and so on.
+func zobristPiece(color Color, piece Piece, square int) uint64 {
+	kind := 2 * int(piece)
+	if color == White {
+		kind++
+	}
+	return zobristRandoms[64*kind+square]
+}
+
+func zobristCastling(flags uint32) uint64 {
+	var key uint64
+	for i := 0; i < 4; i++ {
+		if BitAt32(flags, 6+i) {
+			key ^= zobristRandoms[zobristCastlingOffset+i]
+		}
+	}
+	return key
+}
+
+// zobristEnPassant only hashes the en passant file if a pawn of the side to move can capture on it.
+// Otherwise positions that only differ by an unusable en passant square would differ.
+func zobristEnPassant(b *BitBoard) uint64 {
+	file := b.DoublePawnMoveFile()
+	if file < 1 {
+		return 0
+	}
+
+	var doubleMovedPawn uint64
+	var capturingPawns uint64
+	if b.Turn() == White {
+		doubleMovedPawn = posToBitBoard(32 + file - 1)
+		capturingPawns = b.PawnBB & b.WhiteBB
+	} else {
+		doubleMovedPawn = posToBitBoard(24 + file - 1)
+		capturingPawns = b.PawnBB & b.BlackBB
+	}
+	neighbours := (doubleMovedPawn<<1)&notAFile | (doubleMovedPawn>>1)&notHFile
+	if neighbours&capturingPawns == 0 {
+		return 0
+	}
+	return zobristRandoms[zobristEnPassantOffset+file-1]
+}
+
+func zobristTurn(b *BitBoard) uint64 {
+	if b.Turn() == White {
+		return zobristRandoms[zobristTurnOffset]
+	}
+	return 0
+}
+
+// ComputeZobristKey hashes a board from scratch. transition keeps the key up to date incrementally,
+// so this is only needed when a board is created from something else than a move.
+func ComputeZobristKey(b *BitBoard) uint64 {
+	var key uint64
+	for pos := 0; pos < 64; pos++ {
+		if coloredPiece := b.PieceAt(pos); coloredPiece.piece != Empty {
+			key ^= zobristPiece(coloredPiece.color, coloredPiece.piece, pos)
+		}
+	}
+	return key ^ zobristCastling(b.Flags) ^ zobristEnPassant(b) ^ zobristTurn(b)
+}
+
+func withZobristKey(b BitBoard) BitBoard {
+	b.Key = ComputeZobristKey(&b)
+	return b
+}